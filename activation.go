@@ -0,0 +1,219 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// ErrListenPID is returned by ListenFDsCheck if the file descriptors
+// have been passed to another process, such as the parent of this one.
+var ErrListenPID = errors.New("netutil: LISTEN_PID names another process")
+
+// ListenFDsCheck returns how many file descriptors have been passed to this
+// process by socket activation, starting at 3, like sd_listen_fds(3) does.
+//
+// Absent LISTEN_PID the count is zero. If LISTEN_PID is not this process'
+// ID, ErrListenPID is returned: the descriptors are meant for another one,
+// and are not to be touched.
+func ListenFDsCheck() (int, error) {
+	pidStr, ok := os.LookupEnv("LISTEN_PID")
+	if !ok {
+		return 0, nil
+	}
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		return 0, fmt.Errorf("netutil: malformed LISTEN_PID: %v", err)
+	}
+	if pid != os.Getpid() {
+		return 0, ErrListenPID
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("netutil: malformed LISTEN_FDS: %q", os.Getenv("LISTEN_FDS"))
+	}
+	return n, nil
+}
+
+// ActivatedFiles returns the file descriptors passed to this process by
+// socket activation, as counted by ListenFDsCheck, for AcceptedConnection,
+// ActivatedListeners and the like. They are named as in LISTEN_FDNAMES,
+// else after their number, and are the caller's to close.
+//
+// Unlike go-systemd's activation.Files this leaves the environment alone.
+func ActivatedFiles() ([]*os.File, error) {
+	n, err := ListenFDsCheck()
+	if err != nil {
+		return nil, err
+	}
+	names := listenFDNames(n)
+	files := make([]*os.File, n)
+	for k := range files {
+		fd := listenFDsStart + k
+		name := names[k]
+		if name == "" {
+			name = "LISTEN_FD_" + strconv.Itoa(fd)
+		}
+		files[k] = os.NewFile(uintptr(fd), name)
+	}
+	return files, nil
+}
+
+// TrackedListener pairs a listener with an IdleTracker of its own.
+type TrackedListener struct {
+	net.Listener
+	Tracker *IdleTracker
+}
+
+// ActivatedListeners turns the passed listening sockets, such as returned by
+// ActivatedFiles, into listeners with independent trackers,
+// which derive from the shared parent. The files can be closed afterwards.
+//
+// Wire each tracker's ConnState to the server using its listener, and use
+// AllIdle to learn when all of them are done.
+//
+// Given nil files, it takes those passed to this process, see ActivatedFiles.
+// On Windows this returns ErrNotSupported for any file.
+func ActivatedListeners(parent context.Context, patience time.Duration, files []*os.File, opts ...Option) ([]TrackedListener, error) {
+	if files == nil {
+		var err error
+		if files, err = ActivatedFiles(); err != nil {
+			return nil, err
+		}
+		defer func() {
+			for _, f := range files {
+				f.Close()
+			}
+		}()
+	}
+	tls := make([]TrackedListener, 0, len(files))
+	for _, f := range files {
+		ln, err := fileListener(f)
+		if err != nil {
+			for _, tl := range tls {
+				tl.Close()
+			}
+			return nil, err
+		}
+		tracker, err := NewIdleTrackerErr(parent, patience, opts...)
+		if err != nil {
+			ln.Close()
+			for _, tl := range tls {
+				tl.Close()
+			}
+			return nil, err
+		}
+		tls = append(tls, TrackedListener{Listener: ln, Tracker: tracker})
+	}
+	return tls, nil
+}
+
+// ExportListenFDs returns duplicates of the listeners' sockets, for passing
+// them on to another process such as by an exec-based upgrade, along with
+// names for LISTEN_FDNAMES. Set the files as exec.Cmd.ExtraFiles, in order,
+// which has them start at 3, and the environment as by ListenFDsEnv.
+// Close the files once the process has been started.
+//
+// The names are the listeners' addresses with any colon replaced by an
+// underscore, because colons separate them in LISTEN_FDNAMES.
+//
+// Listeners need to have a method File, as those of package net have,
+// except on Windows which returns an error for it.
+func ExportListenFDs(lns []net.Listener) ([]*os.File, []string, error) {
+	files := make([]*os.File, 0, len(lns))
+	names := make([]string, 0, len(lns))
+	for _, ln := range lns {
+		fl, ok := ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, nil, fmt.Errorf("netutil: listener %T on %v has no File method", ln, ln.Addr())
+		}
+		f, err := fl.File()
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, nil, err
+		}
+		files = append(files, f)
+		names = append(names, strings.Replace(ln.Addr().String(), ":", "_", -1))
+	}
+	return files, names, nil
+}
+
+// ListenFDsEnv returns the environment variables for passing len(names)
+// file descriptors to the process with the given pid, to be appended to
+// those it inherits. See ExportListenFDs.
+//
+// LISTEN_PID is omitted for pid 0. Package os/exec cannot tell the pid before
+// the process has been started, but a shell in between can set it:
+//
+//	cmd := exec.Command("/bin/sh", "-c", `LISTEN_PID=$$ exec "$0" "$@"`, path, args...)
+func ListenFDsEnv(pid int, names []string) []string {
+	env := make([]string, 0, 3)
+	if pid > 0 {
+		env = append(env, "LISTEN_PID="+strconv.Itoa(pid))
+	}
+	return append(env,
+		"LISTEN_FDS="+strconv.Itoa(len(names)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+	)
+}
+
+// ActivatedFD describes a file descriptor passed by socket activation.
+type ActivatedFD struct {
+	FD   int
+	Name string // From LISTEN_FDNAMES, if given.
+
+	// Kind is "listener" for listening sockets, "conn" for connected ones,
+	// "packet" for datagram sockets, "fifo", or "other" for anything else.
+	Kind      string
+	Family    string   // Of sockets: "inet", "inet6", or "unix".
+	LocalAddr net.Addr // Of sockets.
+
+	Err error // Why the above is incomplete, if so.
+}
+
+// ListActivatedFDs describes the file descriptors passed to this process by
+// socket activation, such as to log them on startup. Those that cannot be
+// told apart are listed anyway, with Err set. They are not touched otherwise,
+// and remain to be picked up by ActivatedListeners and the like.
+//
+// It returns what ListenFDsCheck does, should that fail.
+func ListActivatedFDs() ([]ActivatedFD, error) {
+	n, err := ListenFDsCheck()
+	if err != nil {
+		return nil, err
+	}
+	names := listenFDNames(n)
+	fds := make([]ActivatedFD, n)
+	for k := range fds {
+		fds[k] = describeFD(listenFDsStart + k)
+		fds[k].Name = names[k]
+	}
+	return fds, nil
+}
+
+// listenFDNames returns the n names in LISTEN_FDNAMES,
+// or empty names if they don't match the file descriptors.
+func listenFDNames(n int) []string {
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	if len(names) != n {
+		return make([]string, n)
+	}
+	return names
+}
@@ -0,0 +1,89 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+// setenv sets the environment variables for the duration of the test.
+func setenv(t *testing.T, kv ...string) {
+	for n := 0; n+1 < len(kv); n += 2 {
+		key := kv[n]
+		prev, had := os.LookupEnv(key)
+		os.Setenv(key, kv[n+1])
+		t.Cleanup(func() {
+			if had {
+				os.Setenv(key, prev)
+			} else {
+				os.Unsetenv(key)
+			}
+		})
+	}
+}
+
+func TestListenFDsCheck(t *testing.T) {
+	ownPID := strconv.Itoa(os.Getpid())
+	for _, tc := range []struct {
+		pid, fds string
+		want     int
+		wantErr  bool
+	}{
+		{ownPID, "2", 2, false},
+		{ownPID, "0", 0, false},
+		{strconv.Itoa(os.Getpid() + 1), "2", 0, true},
+		{"zwei", "2", 0, true},
+		{ownPID, "", 0, true},
+		{ownPID, "-1", 0, true},
+	} {
+		setenv(t, "LISTEN_PID", tc.pid, "LISTEN_FDS", tc.fds)
+		n, err := netutil.ListenFDsCheck()
+		if (err != nil) != tc.wantErr || n != tc.want {
+			t.Errorf("LISTEN_PID=%s LISTEN_FDS=%s: got %d, %v", tc.pid, tc.fds, n, err)
+		}
+	}
+
+	setenv(t, "LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	if _, err := netutil.ListenFDsCheck(); err != netutil.ErrListenPID {
+		t.Errorf("Expected ErrListenPID for a mismatching LISTEN_PID, got: %v", err)
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	if n, err := netutil.ListenFDsCheck(); n != 0 || err != nil {
+		t.Errorf("Without LISTEN_PID, want 0 and no error, got: %d, %v", n, err)
+	}
+}
+
+func TestActivatedListenersChecksPID(t *testing.T) {
+	setenv(t, "LISTEN_PID", strconv.Itoa(os.Getpid()+1), "LISTEN_FDS", "1")
+	if _, err := netutil.ActivatedFiles(); err != netutil.ErrListenPID {
+		t.Errorf("ActivatedFiles for another process, want ErrListenPID, got: %v", err)
+	}
+	tls, err := netutil.ActivatedListeners(context.Background(), 1*time.Hour, nil)
+	if err != netutil.ErrListenPID || tls != nil {
+		t.Errorf("ActivatedListeners for another process, want ErrListenPID, got: %v, %v", tls, err)
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	if files, err := netutil.ActivatedFiles(); len(files) != 0 || err != nil {
+		t.Errorf("Without LISTEN_PID, want no files, got: %v, %v", files, err)
+	}
+}
+
+func TestListenFDsEnv(t *testing.T) {
+	got := netutil.ListenFDsEnv(4711, []string{"http", "https"})
+	want := []string{"LISTEN_PID=4711", "LISTEN_FDS=2", "LISTEN_FDNAMES=http:https"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("ListenFDsEnv = %q, want %q", got, want)
+	}
+	if got := netutil.ListenFDsEnv(0, []string{"http"}); len(got) != 2 || got[0] != "LISTEN_FDS=1" {
+		t.Errorf("ListenFDsEnv without a pid = %q", got)
+	}
+}
@@ -0,0 +1,253 @@
+// This file is released into the public domain.
+
+//go:build !windows
+// +build !windows
+
+package netutil_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func ExampleActivatedListeners() {
+	// Every socket passed by systemd gets its own tracker,
+	// and the process ends once all are done.
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	tls, err := netutil.ActivatedListeners(ctx, 15*time.Minute, nil)
+	if err != nil {
+		log.Fatalf("netutil.ActivatedListeners: %v", err)
+	}
+
+	trackers := make([]*netutil.IdleTracker, 0, len(tls))
+	for _, tl := range tls {
+		server := &http.Server{ConnState: tl.Tracker.ConnState}
+		go func(tl netutil.TrackedListener) {
+			<-tl.Tracker.Done()
+			server.Shutdown(ctx)
+		}(tl)
+		go server.Serve(tl)
+		trackers = append(trackers, tl.Tracker)
+	}
+
+	<-netutil.AllIdle(trackers...)
+}
+
+func TestActivatedListeners(t *testing.T) {
+	var files []*os.File
+	for n := 0; n < 2; n++ {
+		ln, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatalf("net.Listen: %v", err)
+		}
+		f, err := ln.(*net.TCPListener).File()
+		ln.Close()
+		if err != nil {
+			t.Fatalf("File: %v", err)
+		}
+		files = append(files, f)
+	}
+
+	tls, err := netutil.ActivatedListeners(context.Background(), 50*time.Millisecond, files)
+	for _, f := range files {
+		f.Close()
+	}
+	if err != nil {
+		t.Fatalf("netutil.ActivatedListeners: %v", err)
+	}
+	if len(tls) != 2 {
+		t.Fatalf("Expected two listeners, got: %d", len(tls))
+	}
+	a, b := tls[0], tls[1]
+	defer a.Close()
+	defer b.Close()
+
+	// Keep the second busy.
+	conn := &netutiltest.Conn{}
+	b.Tracker.ConnState(conn, http.StateActive)
+	all := netutil.AllIdle(a.Tracker, b.Tracker)
+
+	select {
+	case <-a.Tracker.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("The idle tracker is not done")
+	}
+	if isClosed(b.Tracker.Done()) || isClosed(all) {
+		t.Fatal("The busy tracker is done with the idle one")
+	}
+
+	b.Tracker.ConnState(conn, http.StateClosed)
+	select {
+	case <-all:
+	case <-time.After(1 * time.Second):
+		t.Fatal("AllIdle did not fire after the second tracker should be done, too")
+	}
+
+	// The listeners are independent of the files passed in.
+	go net.Dial("tcp", a.Addr().String())
+	if c, err := a.Accept(); err != nil {
+		t.Errorf("Accept: %v", err)
+	} else {
+		c.Close()
+	}
+}
+
+func TestExportListenFDs(t *testing.T) {
+	var lns []net.Listener
+	for n := 0; n < 2; n++ {
+		ln, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatalf("net.Listen: %v", err)
+		}
+		defer ln.Close()
+		lns = append(lns, ln)
+	}
+	files, names, err := netutil.ExportListenFDs(lns)
+	if err != nil {
+		t.Fatalf("netutil.ExportListenFDs: %v", err)
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", `LISTEN_PID=$$ exec "$0" "$@"`,
+		os.Args[0], "-test.run=^TestListenFDsChild$")
+	cmd.Env = append(os.Environ(), "NETUTIL_LISTEN_FDS_CHILD=1")
+	cmd.Env = append(cmd.Env, netutil.ListenFDsEnv(0, names)...)
+	cmd.ExtraFiles = files
+	out, err := cmd.Output()
+	for _, f := range files {
+		f.Close()
+	}
+	if err != nil {
+		t.Fatalf("The child failed: %v\n%s", err, out)
+	}
+
+	var got []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "fd ") {
+			got = append(got, line)
+		}
+	}
+	if len(got) != len(lns) {
+		t.Fatalf("The child reported %d listeners, want %d:\n%s", len(got), len(lns), out)
+	}
+	for k, ln := range lns {
+		want := fmt.Sprintf("fd %d %s %s", 3+k, names[k], ln.Addr())
+		if got[k] != want {
+			t.Errorf("The child reported %q, want %q", got[k], want)
+		}
+	}
+}
+
+// TestListenFDsChild is run by TestExportListenFDs in another process.
+func TestListenFDsChild(t *testing.T) {
+	if os.Getenv("NETUTIL_LISTEN_FDS_CHILD") != "1" {
+		t.Skip("Only run by TestExportListenFDs")
+	}
+	n, err := netutil.ListenFDsCheck()
+	if err != nil {
+		t.Fatalf("netutil.ListenFDsCheck: %v", err)
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	if len(names) != n {
+		t.Fatalf("LISTEN_FDNAMES has %d names for %d file descriptors", len(names), n)
+	}
+	for k := 0; k < n; k++ {
+		f := os.NewFile(uintptr(3+k), names[k])
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("net.FileListener(%d): %v", 3+k, err)
+		}
+		fmt.Printf("fd %d %s %s\n", 3+k, names[k], ln.Addr())
+		ln.Close()
+	}
+}
+
+func TestListActivatedFDs(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer client.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.ListenPacket: %v", err)
+	}
+	defer pc.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	var files []*os.File
+	for _, fl := range []interface{ File() (*os.File, error) }{
+		ln.(*net.TCPListener), client.(*net.TCPConn), pc.(*net.UDPConn),
+	} {
+		f, err := fl.File()
+		if err != nil {
+			t.Fatalf("File: %v", err)
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+	files = append(files, r)
+
+	cmd := exec.Command("/bin/sh", "-c", `LISTEN_PID=$$ exec "$0" "$@"`,
+		os.Args[0], "-test.run=^TestListActivatedFDsChild$")
+	cmd.Env = append(os.Environ(), "NETUTIL_LIST_FDS_CHILD=1")
+	cmd.Env = append(cmd.Env, netutil.ListenFDsEnv(0, []string{"http", "client", "dns", "fifo"})...)
+	cmd.ExtraFiles = files
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("The child failed: %v\n%s", err, out)
+	}
+
+	want := []string{
+		fmt.Sprintf("fd 3 http listener inet %s <nil>", ln.Addr()),
+		fmt.Sprintf("fd 4 client conn inet %s <nil>", client.LocalAddr()),
+		fmt.Sprintf("fd 5 dns packet inet %s <nil>", pc.LocalAddr()),
+		"fd 6 fifo fifo  <nil> <nil>",
+	}
+	var got []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "fd ") {
+			got = append(got, line)
+		}
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("The child reported:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// TestListActivatedFDsChild is run by TestListActivatedFDs in another process.
+func TestListActivatedFDsChild(t *testing.T) {
+	if os.Getenv("NETUTIL_LIST_FDS_CHILD") != "1" {
+		t.Skip("Only run by TestListActivatedFDs")
+	}
+	fds, err := netutil.ListActivatedFDs()
+	if err != nil {
+		t.Fatalf("netutil.ListActivatedFDs: %v", err)
+	}
+	for _, fd := range fds {
+		fmt.Printf("fd %d %s %s %s %v %v\n", fd.FD, fd.Name, fd.Kind, fd.Family, fd.LocalAddr, fd.Err)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"math"
+	"time"
+)
+
+// adaptivePatience scales patience with the rate of recent connections.
+//
+// Every new connection adds one to a score, which decays with a half-life
+// of max. The patience is min + (max-min) * score/(score+1), so it is min
+// absent any recent connections, halfway with about one, and approaches
+// max the busier it gets.
+type adaptivePatience struct {
+	min, max time.Duration
+
+	score float64
+	at    time.Time // Of the last update to score.
+}
+
+// decayed is the score as of now.
+func (a *adaptivePatience) decayed(now time.Time) float64 {
+	if a.score == 0 {
+		return 0
+	}
+	halvings := float64(now.Sub(a.at)) / float64(a.max)
+	return a.score * math.Exp2(-halvings)
+}
+
+// observe records a new connection.
+func (a *adaptivePatience) observe(now time.Time) {
+	a.score = a.decayed(now) + 1
+	a.at = now
+}
+
+// patience returns the patience to use as of now.
+func (a *adaptivePatience) patience(now time.Time) time.Duration {
+	s := a.decayed(now)
+	return a.min + time.Duration(float64(a.max-a.min)*s/(s+1))
+}
@@ -0,0 +1,41 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"net"
+	"strings"
+)
+
+// remoteIP returns the IP address of the connection's peer,
+// or nil if it has none (like a Unix domain socket).
+func remoteIP(c net.Conn) net.IP {
+	switch addr := c.RemoteAddr().(type) {
+	case nil:
+		return nil
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.IPAddr:
+		return addr.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return nil
+		}
+		return net.ParseIP(stripZone(host))
+	}
+}
+
+// stripZone removes any zone from an IPv6 address,
+// such as "eth0" of "fe80::1%eth0", which net.ParseIP would reject.
+// The zone doesn't change which networks the address belongs to.
+func stripZone(host string) string {
+	if i := strings.LastIndexByte(host, '%'); i >= 0 {
+		return host[:i]
+	}
+	return host
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"net"
+)
+
+// AllowlistListener returns a net.Listener that closes connections from
+// peers outside the allowed networks right away, instead of returning them.
+// Peers without an IP address, such as of Unix domain sockets, are rejected.
+func AllowlistListener(ln net.Listener, allowed []*net.IPNet) net.Listener {
+	return AllowlistListenerFunc(ln, allowed, nil)
+}
+
+// AllowlistListenerFunc is AllowlistListener that calls onReject,
+// if not nil, with every connection it rejects, before closing it.
+// Use this to log rejects.
+func AllowlistListenerFunc(ln net.Listener, allowed []*net.IPNet, onReject func(net.Conn)) net.Listener {
+	return &allowlistListener{Listener: ln, allowed: allowed, onReject: onReject}
+}
+
+// allowlistListener implements net.Listener.
+type allowlistListener struct {
+	net.Listener
+	allowed  []*net.IPNet
+	onReject func(net.Conn)
+}
+
+// Accept implements net.Listener.
+func (l *allowlistListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.allows(remoteIP(c)) {
+			return c, nil
+		}
+		if l.onReject != nil {
+			l.onReject(c)
+		}
+		c.Close()
+	}
+}
+
+func (l *allowlistListener) allows(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range l.allowed {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
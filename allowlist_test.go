@@ -0,0 +1,118 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatalf("net.ParseCIDR(%q): %v", s, err)
+	}
+	return n
+}
+
+func TestAllowlistListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ln := netutil.AllowlistListener(inner, []*net.IPNet{
+		mustCIDR(t, "10.0.0.0/8"), mustCIDR(t, "127.0.0.0/8"),
+	})
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("A peer in range should have been accepted, got: %v", err)
+	}
+	conn.Close()
+}
+
+func TestAllowlistListenerRejects(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	rejected := make(chan net.Addr, 1)
+	ln := netutil.AllowlistListenerFunc(inner, []*net.IPNet{mustCIDR(t, "10.0.0.0/8")},
+		func(c net.Conn) { rejected <- c.RemoteAddr() })
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer client.Close()
+	select {
+	case addr := <-rejected:
+		if addr.String() != client.LocalAddr().String() {
+			t.Errorf("Rejected %v, want %v", addr, client.LocalAddr())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The peer out of range has not been rejected")
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if b, err := ioutil.ReadAll(client); err != nil || len(b) != 0 {
+		t.Errorf("The rejected connection should have been closed, got: %q, %v", b, err)
+	}
+
+	ln.Close()
+	if c := <-accepted; c != nil {
+		t.Errorf("Accept returned a connection out of range: %v", c.RemoteAddr())
+	}
+}
+
+// stringAddr is a net.Addr that is known by its string form only.
+type stringAddr string
+
+func (a stringAddr) Network() string { return "tcp" }
+func (a stringAddr) String() string  { return string(a) }
+
+func TestAllowlistListenerZones(t *testing.T) {
+	scoped := &netutiltest.Conn{Remote: &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 4711, Zone: "eth0"}}
+	opaque := &netutiltest.Conn{Remote: stringAddr("[fe80::2%eth0]:4711")}
+	outside := &netutiltest.Conn{Remote: stringAddr("[2001:db8::1%3]:4711")}
+	inner := newScriptedListener(scoped, opaque, outside)
+	rejected := make(chan net.Conn, 1)
+	ln := netutil.AllowlistListenerFunc(inner, []*net.IPNet{mustCIDR(t, "fe80::/10")},
+		func(c net.Conn) { rejected <- c })
+	defer ln.Close()
+
+	for _, want := range []net.Conn{scoped, opaque} {
+		c, err := ln.Accept()
+		if err != nil {
+			t.Fatalf("Accept: %v", err)
+		}
+		if c != want {
+			t.Errorf("Accepted %v, want the link-local peer %v", c.RemoteAddr(), want.RemoteAddr())
+		}
+	}
+	go ln.Accept()
+	select {
+	case c := <-rejected:
+		if c != outside {
+			t.Errorf("Rejected %v, want %v", c.RemoteAddr(), outside.RemoteAddr())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The peer out of range has not been rejected")
+	}
+}
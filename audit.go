@@ -0,0 +1,112 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AuditEventKind tells the stages of a connection's lifecycle apart.
+type AuditEventKind int
+
+// Stages of connections reported by AuditListener.
+const (
+	AuditAccept AuditEventKind = iota
+	AuditClose
+)
+
+// String implements the fmt.Stringer interface.
+func (k AuditEventKind) String() string {
+	switch k {
+	case AuditAccept:
+		return "accept"
+	case AuditClose:
+		return "close"
+	}
+	return "unknown"
+}
+
+// AuditEvent describes a connection that has been accepted or closed.
+type AuditEvent struct {
+	Kind       AuditEventKind
+	Time       time.Time
+	LocalAddr  net.Addr
+	RemoteAddr net.Addr
+
+	// BytesIn and BytesOut are the totals read from and written to the
+	// connection. They are only set on AuditClose.
+	BytesIn, BytesOut int64
+}
+
+// AuditListener returns a net.Listener that calls sink for every connection
+// it accepts, and again once that connection is closed.
+//
+// sink is called synchronously, by Accept and Close respectively.
+func AuditListener(ln net.Listener, sink func(AuditEvent)) net.Listener {
+	return &auditListener{Listener: ln, sink: sink}
+}
+
+// auditListener implements net.Listener.
+type auditListener struct {
+	net.Listener
+	sink func(AuditEvent)
+}
+
+// Accept implements net.Listener.
+func (l *auditListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.sink(AuditEvent{
+		Kind:       AuditAccept,
+		Time:       time.Now(),
+		LocalAddr:  c.LocalAddr(),
+		RemoteAddr: c.RemoteAddr(),
+	})
+	return &auditedConn{Conn: c, sink: l.sink}, nil
+}
+
+// auditedConn counts the bytes that went through it.
+type auditedConn struct {
+	bytesIn, bytesOut int64 // Accessed atomically, hence first for alignment.
+
+	net.Conn
+	sink      func(AuditEvent)
+	closeOnce sync.Once
+}
+
+// Read implements net.Conn.
+func (c *auditedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.bytesIn, int64(n))
+	return n, err
+}
+
+// Write implements net.Conn.
+func (c *auditedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.bytesOut, int64(n))
+	return n, err
+}
+
+// Close implements net.Conn.
+func (c *auditedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		c.sink(AuditEvent{
+			Kind:       AuditClose,
+			Time:       time.Now(),
+			LocalAddr:  c.LocalAddr(),
+			RemoteAddr: c.RemoteAddr(),
+			BytesIn:    atomic.LoadInt64(&c.bytesIn),
+			BytesOut:   atomic.LoadInt64(&c.bytesOut),
+		})
+	})
+	return err
+}
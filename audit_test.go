@@ -0,0 +1,70 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func TestAuditListener(t *testing.T) {
+	var mu sync.Mutex
+	var events []netutil.AuditEvent
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ln = netutil.AuditListener(ln, func(e netutil.AuditEvent) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	})
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+
+	client.Write([]byte("ping-ping"))
+	buf := make([]byte, 9)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	conn.Write([]byte("pong"))
+	conn.Close()
+	conn.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 {
+		t.Fatalf("Expected two events, got %d: %v", len(events), events)
+	}
+	accept, closed := events[0], events[1]
+	if accept.Kind != netutil.AuditAccept || closed.Kind != netutil.AuditClose {
+		t.Errorf("Unexpected kinds of events: %v, %v", accept.Kind, closed.Kind)
+	}
+	for _, e := range events {
+		if e.RemoteAddr.String() != client.LocalAddr().String() {
+			t.Errorf("%v: RemoteAddr = %v, want %v", e.Kind, e.RemoteAddr, client.LocalAddr())
+		}
+		if e.LocalAddr.String() != ln.Addr().String() {
+			t.Errorf("%v: LocalAddr = %v, want %v", e.Kind, e.LocalAddr, ln.Addr())
+		}
+	}
+	if closed.Time.Before(accept.Time) {
+		t.Errorf("The close event predates the accept event")
+	}
+	if closed.BytesIn != 9 || closed.BytesOut != 4 {
+		t.Errorf("Bytes in/out = %d/%d, want 9/4", closed.BytesIn, closed.BytesOut)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"net"
+	"os"
+	"sync"
+)
+
+// AutoListener returns a net.Listener for f, be it a listening socket or a
+// connection that has already been accepted, such as with systemd's
+// Accept=yes, in which case it behaves like the one of AcceptedConnection.
+//
+// It doesn't rely on the kernel to tell those apart: should the first
+// Accept fail as if f was not listening, it falls back to the latter.
+// The caller retains ownership of f.
+//
+// On Windows this returns ErrNotSupported.
+func AutoListener(f *os.File) (net.Listener, error) {
+	file, err := dupFile(f)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := fileListener(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &autoListener{Listener: ln, file: file}, nil
+}
+
+// autoListener implements net.Listener.
+type autoListener struct {
+	mu sync.Mutex
+	net.Listener
+	file *os.File // For the fallback, until decided.
+}
+
+// Accept implements net.Listener.
+func (l *autoListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	ln, undecided := l.Listener, l.file != nil
+	l.mu.Unlock()
+
+	c, err := ln.Accept()
+	if !undecided {
+		return c, err
+	}
+	l.mu.Lock()
+	if l.file == nil || ln != l.Listener { // Decided meanwhile.
+		l.mu.Unlock()
+		return c, err
+	}
+	if err == nil || !notListening(err) {
+		if err == nil {
+			l.file.Close()
+			l.file = nil
+		}
+		l.mu.Unlock()
+		return c, err
+	}
+
+	accepted, aErr := AcceptedConnection(l.file)
+	if aErr != nil {
+		l.mu.Unlock()
+		return nil, err
+	}
+	l.Listener.Close()
+	l.Listener = accepted
+	l.file.Close()
+	l.file = nil
+	l.mu.Unlock()
+	return accepted.Accept()
+}
+
+// Close implements net.Listener.
+func (l *autoListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	return l.Listener.Close()
+}
+
+// Addr implements net.Listener.
+func (l *autoListener) Addr() net.Addr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.Listener.Addr()
+}
@@ -0,0 +1,14 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package netutil
+
+// notListening is always false where AcceptedConnection is not supported,
+// leaving any error of Accept as it is.
+func notListening(err error) bool {
+	return false
+}
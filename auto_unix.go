@@ -0,0 +1,19 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package netutil
+
+import (
+	"errors"
+	"syscall"
+)
+
+// notListening tells whether err is what accept(2) on a socket results in
+// that is connected instead of listening.
+func notListening(err error) bool {
+	return errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOTSOCK)
+}
@@ -0,0 +1,86 @@
+// This file is released into the public domain.
+
+//go:build !windows
+// +build !windows
+
+package netutil_test
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func TestAutoListenerConnectedSocket(t *testing.T) {
+	// net.FileListener takes a connected socket for a listening one,
+	// which becomes apparent only on Accept.
+	f, client := acceptedFile(t)
+	defer client.Close()
+	defer f.Close()
+	ln, err := netutil.AutoListener(f)
+	if err != nil {
+		t.Fatalf("netutil.AutoListener: %v", err)
+	}
+	defer ln.Close()
+
+	go client.Write([]byte("GET"))
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept should have fallen back to the accepted connection, got: %v", err)
+	}
+	buf := make([]byte, 3)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "GET" {
+		t.Errorf("Failed to read what the client sent: %q, %v", buf, err)
+	}
+
+	next := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		next <- err
+	}()
+	conn.Close()
+	select {
+	case err := <-next:
+		if err != os.ErrClosed {
+			t.Errorf("A subsequent Accept should return os.ErrClosed, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("A subsequent Accept still blocks after the connection has been closed")
+	}
+}
+
+func TestAutoListenerListeningSocket(t *testing.T) {
+	inner, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer inner.Close()
+	f, err := inner.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("File: %v", err)
+	}
+	defer f.Close()
+	ln, err := netutil.AutoListener(f)
+	if err != nil {
+		t.Fatalf("netutil.AutoListener: %v", err)
+	}
+	defer ln.Close()
+
+	for n := 0; n < 2; n++ {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("net.Dial: %v", err)
+		}
+		conn, err := ln.Accept()
+		if err != nil {
+			t.Fatalf("Accept on a listening socket: %v", err)
+		}
+		conn.Close()
+		client.Close()
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shutdownGrace bounds the context the servers bound by BindServers get shut down with.
+const shutdownGrace = 30 * time.Second
+
+// BindServers hooks the tracker into each server's ConnState, in front of
+// any func there, and has them shut down concurrently once the tracker is
+// done, allowing up to 30 seconds for that, or as set by WithShutdownBudget.
+//
+// Call it before any server serves, else connections accepted until then
+// go unaccounted for.
+//
+// Use ShutdownErr to wait for the shutdowns and learn about any failures.
+func (t *IdleTracker) BindServers(servers ...*http.Server) {
+	for _, s := range servers {
+		next := s.ConnState
+		s.ConnState = func(c net.Conn, state http.ConnState) {
+			t.ConnState(c, state)
+			if next != nil {
+				next(c, state)
+			}
+		}
+	}
+
+	t.shutdowns.Add(1)
+	go func() {
+		defer t.shutdowns.Done()
+		<-t.Done()
+		var wg sync.WaitGroup
+		errs := make([]error, len(servers))
+		for k, s := range servers {
+			wg.Add(1)
+			go func(k int, s *http.Server) {
+				defer wg.Done()
+				errs[k] = t.shutdownServer(s, shutdownGrace)
+			}(k, s)
+		}
+		wg.Wait()
+
+		t.mu.Lock()
+		for _, err := range errs {
+			if err != nil {
+				t.shutdownErrs = append(t.shutdownErrs, err)
+			}
+		}
+		t.mu.Unlock()
+	}()
+}
+
+// ShutdownErr waits for the servers bound by BindServers to have been shut
+// down, and returns what any Shutdown failed with. It doesn't wait if no
+// server has been bound. Don't call it concurrently with BindServers.
+func (t *IdleTracker) ShutdownErr() error {
+	t.shutdowns.Wait()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	switch len(t.shutdownErrs) {
+	case 0:
+		return nil
+	case 1:
+		return t.shutdownErrs[0]
+	}
+	return shutdownErrors(t.shutdownErrs)
+}
+
+// shutdownErrors is the error of several failed Shutdowns.
+type shutdownErrors []error
+
+func (errs shutdownErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for k, err := range errs {
+		msgs[k] = err.Error()
+	}
+	return "netutil: shutting down servers: " + strings.Join(msgs, "; ")
+}
+
+// shutdownServer shuts down s gracefully, allowing up to grace for that,
+// unless a budget has been set by WithShutdownBudget.
+func (t *IdleTracker) shutdownServer(s *http.Server, grace time.Duration) error {
+	t.mu.RLock()
+	total, steps := t.shutdownBudget, t.shutdownSteps
+	wait := t.graceUntil.Sub(t.now())
+	t.mu.RUnlock()
+	if wait > 0 { // See WithLastRequestGrace.
+		time.Sleep(wait)
+	}
+	if total <= 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), grace)
+		defer cancel()
+		return s.Shutdown(ctx)
+	}
+
+	start := time.Now()
+	var attempts []error
+	for k := 1; k <= steps; k++ {
+		deadline := start.Add(total * time.Duration(k) / time.Duration(steps))
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		err := s.Shutdown(ctx)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		attempts = append(attempts, err)
+	}
+	return &ShutdownError{Attempts: attempts, CloseErr: s.Close()}
+}
+
+// ShutdownError tells that server.Shutdown didn't succeed within the budget
+// set by WithShutdownBudget, and the server has been closed instead.
+type ShutdownError struct {
+	Attempts []error // Of server.Shutdown, in order.
+	CloseErr error   // Of server.Close.
+}
+
+func (e *ShutdownError) Error() string {
+	msgs := make([]string, 0, len(e.Attempts)+1)
+	for k, err := range e.Attempts {
+		msgs = append(msgs, fmt.Sprintf("shutdown attempt %d: %v", k+1, err))
+	}
+	if e.CloseErr != nil {
+		msgs = append(msgs, "close: "+e.CloseErr.Error())
+	} else {
+		msgs = append(msgs, "closed")
+	}
+	return "netutil: escalated shutdown: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the error of the last attempt to shut down.
+func (e *ShutdownError) Unwrap() error {
+	if len(e.Attempts) == 0 {
+		return nil
+	}
+	return e.Attempts[len(e.Attempts)-1]
+}
@@ -0,0 +1,147 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func TestBindServers(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 100*time.Millisecond)
+	servers := []*http.Server{
+		{Handler: http.NotFoundHandler()},
+		{Handler: http.NotFoundHandler()},
+	}
+	i.BindServers(servers...)
+
+	served := make(chan error, len(servers))
+	addrs := make([]string, len(servers))
+	for k, s := range servers {
+		ln, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatalf("net.Listen: %v", err)
+		}
+		addrs[k] = ln.Addr().String()
+		go func(s *http.Server) { served <- s.Serve(ln) }(s)
+	}
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	for _, addr := range addrs {
+		resp, err := client.Get("http://" + addr + "/")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if !i.EverActive() {
+		t.Error("ConnState has not been wired to the servers")
+	}
+
+	for range servers {
+		select {
+		case err := <-served:
+			if err != http.ErrServerClosed {
+				t.Errorf("Serve should have returned http.ErrServerClosed, got: %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Not all servers have been shut down after the tracker fired")
+		}
+	}
+	if err := i.ShutdownErr(); err != nil {
+		t.Errorf("ShutdownErr = %v", err)
+	}
+}
+
+func TestShutdownBudgetEscalatesToClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	i := netutil.NewIdleTracker(ctx, 1*time.Hour,
+		netutil.WithShutdownBudget(100*time.Millisecond, 2))
+
+	inHandler := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(inHandler)
+		select { // Keeps Shutdown from succeeding.
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})}
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+
+	type result struct {
+		res netutil.ServeResult
+		err error
+	}
+	served := make(chan result, 1)
+	go func() {
+		res, err := i.ServeUntilIdle(server, ln, 1*time.Hour)
+		served <- result{res, err}
+	}()
+	clientErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err == nil {
+			_, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		clientErr <- err
+	}()
+	<-inHandler
+	cancel()
+
+	var r result
+	select {
+	case r = <-served:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServeUntilIdle has not returned within the budget")
+	}
+	var se *netutil.ShutdownError
+	if !errors.As(r.err, &se) {
+		t.Fatalf("ServeUntilIdle returned %v, want a *ShutdownError", r.err)
+	}
+	if len(se.Attempts) != 2 || se.CloseErr != nil {
+		t.Errorf("Got %d attempts and CloseErr %v, want 2 and nil", len(se.Attempts), se.CloseErr)
+	}
+	if !errors.Is(r.err, context.DeadlineExceeded) {
+		t.Errorf("%v should wrap context.DeadlineExceeded", r.err)
+	}
+	if r.res.Drain < 90*time.Millisecond {
+		t.Errorf("Drain = %v, the budget has not been used up", r.res.Drain)
+	}
+
+	select {
+	case err := <-clientErr:
+		if err == nil {
+			t.Error("The request should have been cut off by Close")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Close has not ended the connection")
+	}
+}
+
+func TestWithShutdownBudgetInvalid(t *testing.T) {
+	for _, steps := range []int{0, -1} {
+		if _, err := netutil.NewIdleTrackerErr(context.Background(), time.Minute,
+			netutil.WithShutdownBudget(time.Second, steps)); err == nil {
+			t.Errorf("WithShutdownBudget(1s, %d) should have been rejected", steps)
+		}
+	}
+	if _, err := netutil.NewIdleTrackerErr(context.Background(), time.Minute,
+		netutil.WithShutdownBudget(0, 1)); err == nil {
+		t.Error("WithShutdownBudget(0, 1) should have been rejected")
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connShards is how many shards WithConcurrentBookkeeping splits the
+// tracked connections into.
+const connShards = 64
+
+// WithConcurrentBookkeeping keeps the tracked connections in a sharded set,
+// so that ConnState, called from many goroutines at once, mostly gets by
+// with the tracker's read lock and that of one shard. This is for
+// services that see very many connections per second on many CPUs.
+//
+// Only changes that leave the tracker busy take that path. The first
+// connection, the last one, and anything that reports an event, feeds
+// WithAdaptivePatience or WithSpikeShutdown, or belongs to a class,
+// see ConnStateFor, go through the tracker's lock as they do by default.
+// Hence the tracker is idle if and only if nothing is tracked, and its
+// timer is armed if and only if it is idle, as without this option.
+//
+// Connections told apart by WithConnKey should be keyed by pointers or
+// strings, else getting their shard takes a detour through fmt.
+func WithConcurrentBookkeeping() Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithConcurrentBookkeeping"); err != nil {
+			return err
+		}
+		t.concurrent = true
+		t.dangling = newConnSet(connShards)
+		return nil
+	}
+}
+
+// connSet holds the tracked connections by connKey.
+//
+// By default it is a plain map guarded by the tracker's lock. If sharded,
+// see WithConcurrentBookkeeping, every shard has a lock of its own, and
+// fastConnState can change it holding only the tracker's read lock.
+type connSet struct {
+	n      int64 // If sharded, accessed atomically. First for its alignment.
+	m      map[interface{}]*connInfo
+	shards []connShard
+}
+
+type connShard struct {
+	mu sync.Mutex
+	m  map[interface{}]*connInfo
+	_  [48]byte // Keeps shards apart in cache lines.
+}
+
+// newConnSet returns a connSet with as many shards, or a plain one for zero.
+func newConnSet(shards int) *connSet {
+	if shards == 0 {
+		return &connSet{m: make(map[interface{}]*connInfo)}
+	}
+	s := &connSet{shards: make([]connShard, shards)}
+	for k := range s.shards {
+		s.shards[k].m = make(map[interface{}]*connInfo)
+	}
+	return s
+}
+
+func (s *connSet) sharded() bool {
+	return s.shards != nil
+}
+
+// shardOf returns the shard key belongs to.
+func (s *connSet) shardOf(key interface{}) *connShard {
+	var h uint64
+	switch k := key.(type) {
+	case string:
+		f := fnv.New64a()
+		f.Write([]byte(k))
+		h = f.Sum64()
+	default:
+		switch v := reflect.ValueOf(key); v.Kind() {
+		case reflect.Ptr, reflect.UnsafePointer, reflect.Chan:
+			h = uint64(v.Pointer())
+			h ^= h >> 7 // Low bits are zero by alignment.
+			h ^= h >> 17
+		default:
+			f := fnv.New64a()
+			fmt.Fprint(f, key)
+			h = f.Sum64()
+		}
+	}
+	return &s.shards[h%uint64(len(s.shards))]
+}
+
+func (s *connSet) len() int {
+	if !s.sharded() {
+		return len(s.m)
+	}
+	return int(atomic.LoadInt64(&s.n))
+}
+
+func (s *connSet) get(key interface{}) (*connInfo, bool) {
+	if !s.sharded() {
+		ci, found := s.m[key]
+		return ci, found
+	}
+	sh := s.shardOf(key)
+	sh.mu.Lock()
+	ci, found := sh.m[key]
+	sh.mu.Unlock()
+	return ci, found
+}
+
+// put adds or replaces what is tracked under key.
+// Must be called with the tracker's write lock held.
+func (s *connSet) put(key interface{}, ci *connInfo) {
+	if !s.sharded() {
+		s.m[key] = ci
+		return
+	}
+	sh := s.shardOf(key)
+	sh.mu.Lock()
+	if _, found := sh.m[key]; !found {
+		atomic.AddInt64(&s.n, 1)
+	}
+	sh.m[key] = ci
+	sh.mu.Unlock()
+}
+
+// del removes key. Must be called with the tracker's write lock held.
+func (s *connSet) del(key interface{}) {
+	if !s.sharded() {
+		delete(s.m, key)
+		return
+	}
+	sh := s.shardOf(key)
+	sh.mu.Lock()
+	if _, found := sh.m[key]; found {
+		delete(sh.m, key)
+		atomic.AddInt64(&s.n, -1)
+	}
+	sh.mu.Unlock()
+}
+
+// each calls fn for every tracked connection until fn returns false.
+// fn must not change the set.
+func (s *connSet) each(fn func(key interface{}, ci *connInfo) bool) {
+	if !s.sharded() {
+		for key, ci := range s.m {
+			if !fn(key, ci) {
+				return
+			}
+		}
+		return
+	}
+	for k := range s.shards {
+		sh := &s.shards[k]
+		sh.mu.Lock()
+		for key, ci := range sh.m {
+			if !fn(key, ci) {
+				sh.mu.Unlock()
+				return
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// conns returns the tracked connections.
+func (s *connSet) conns() []net.Conn {
+	conns := make([]net.Conn, 0, s.len())
+	s.each(func(_ interface{}, ci *connInfo) bool {
+		conns = append(conns, ci.conn)
+		return true
+	})
+	return conns
+}
+
+// tryGrow counts one more connection, unless that would have the tracker go
+// from idle to busy, which otherwiseBusy tells it isn't.
+func (s *connSet) tryGrow(otherwiseBusy bool) bool {
+	for {
+		n := atomic.LoadInt64(&s.n)
+		if n == 0 && !otherwiseBusy {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&s.n, n, n+1) {
+			return true
+		}
+	}
+}
+
+// tryShrink counts one connection less, unless that would have the tracker
+// go from busy to idle.
+func (s *connSet) tryShrink(otherwiseBusy bool) bool {
+	for {
+		n := atomic.LoadInt64(&s.n)
+		if n <= 1 && !otherwiseBusy {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&s.n, n, n-1) {
+			return true
+		}
+	}
+}
+
+// fastConnState is connState for a sharded connSet, which holds only the
+// read lock. It reports false if the change needs the write lock, such as
+// for the tracker to go from idle to busy or back, and has done nothing then.
+func (t *IdleTracker) fastConnState(key interface{}, conn net.Conn, state http.ConnState) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.forward != nil || t.permErr != nil || t.awaitingStart ||
+		state == http.StateActive && !t.everActive {
+		return false
+	}
+	// Else there would be EventConnNew or EventConnClosed to emit.
+	quiet := len(t.eventHooks) == 0 && t.events.buf == nil
+	otherwiseBusy := t.inflight > 0 || t.holds > 0 || t.paused
+
+	sh := t.dangling.shardOf(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	ci, tracked := sh.m[key]
+	now := t.now()
+	switch state {
+	case http.StateNew, http.StateActive:
+		if tracked {
+			t.noteActiveShared(ci, now)
+			ci.conn, ci.state = conn, state
+			return true
+		}
+		if !quiet || state == http.StateNew && (t.adaptive != nil || t.spike != nil) ||
+			!t.dangling.tryGrow(otherwiseBusy) {
+			return false
+		}
+		sh.m[key] = &connInfo{conn: conn, state: state, since: now}
+		return true
+	case http.StateIdle, http.StateClosed:
+		if state == http.StateIdle && t.keepAliveCounts {
+			if tracked {
+				t.noteActiveShared(ci, now)
+				ci.state = state
+			}
+			return true
+		}
+		if !tracked {
+			return true
+		}
+		// The class deadline, as untrack notes it, needs the patience to be
+		// that of the tracker, and not to change with every connection.
+		if !quiet || ci.patience > 0 || t.adaptive != nil ||
+			!t.dangling.tryShrink(otherwiseBusy) {
+			return false
+		}
+		t.noteActiveShared(ci, now)
+		delete(sh.m, key)
+		storeLater(&t.notedClassDeadline, t.countdownStart(now).Add(t.nextPatience()))
+		return true
+	}
+	return false
+}
+
+// noteActiveShared is noteActive with only the read lock held,
+// but the lock of the connection's shard.
+func (t *IdleTracker) noteActiveShared(ci *connInfo, now time.Time) {
+	if ci.state != http.StateActive {
+		return
+	}
+	ci.lastActive = now
+	storeLater(&t.notedActive, now)
+}
+
+// storeLater sets *addr to at in UnixNano, unless it is later already.
+func storeLater(addr *int64, at time.Time) {
+	ns := at.UnixNano()
+	for {
+		old := atomic.LoadInt64(addr)
+		if old != 0 && old >= ns {
+			return
+		}
+		if atomic.CompareAndSwapInt64(addr, old, ns) {
+			return
+		}
+	}
+}
+
+// loadNoted returns what storeLater has set, or the zero time.
+func loadNoted(addr *int64) time.Time {
+	if ns := atomic.LoadInt64(addr); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// lastActiveAt is lastActive, including what fastConnState has noted.
+// Must be called with the lock held.
+func (t *IdleTracker) lastActiveAt() time.Time {
+	if noted := loadNoted(&t.notedActive); noted.After(t.lastActive) {
+		return noted
+	}
+	return t.lastActive
+}
+
+// takeClassDeadline returns classDeadline, including what fastConnState has
+// noted, and resets both. Must be called with the write lock held.
+func (t *IdleTracker) takeClassDeadline() time.Time {
+	deadline := t.classDeadline
+	if noted := loadNoted(&t.notedClassDeadline); noted.After(deadline) {
+		deadline = noted
+	}
+	t.classDeadline = time.Time{}
+	atomic.StoreInt64(&t.notedClassDeadline, 0)
+	return deadline
+}
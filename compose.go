@@ -0,0 +1,81 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// AllIdle returns a channel that gets closed once all trackers are done,
+// which is right away if none are given.
+//
+// Use this to end a process that hosts several services,
+// each with an IdleTracker of its own, once none of them is in use.
+func AllIdle(trackers ...*IdleTracker) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for _, t := range trackers {
+			<-t.Done()
+		}
+		close(done)
+	}()
+	return done
+}
+
+// AnyIdle returns a channel that gets closed as soon as any tracker is done.
+// If none are given it will never be closed. See AnyIdleContext for the
+// reason.
+func AnyIdle(trackers ...*IdleTracker) <-chan struct{} {
+	return AnyIdleContext(trackers...).Done()
+}
+
+// AnyIdleContext is AnyIdle as a context, whose Err is that of the tracker
+// which has been done first.
+func AnyIdleContext(trackers ...*IdleTracker) context.Context {
+	c := &anyIdleContext{done: make(chan struct{})}
+	for _, t := range trackers {
+		go func(t *IdleTracker) {
+			select {
+			case <-t.Done():
+				c.once.Do(func() {
+					c.err = t.Err()
+					close(c.done)
+				})
+			case <-c.done: // Another has been first.
+			}
+		}(t)
+	}
+	return c
+}
+
+// anyIdleContext implements context.Context, see AnyIdleContext.
+type anyIdleContext struct {
+	done chan struct{}
+	once sync.Once
+	err  error // Set before done gets closed.
+}
+
+func (*anyIdleContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c *anyIdleContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *anyIdleContext) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+func (*anyIdleContext) Value(interface{}) interface{} {
+	return nil
+}
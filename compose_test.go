@@ -0,0 +1,111 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestAllIdle(t *testing.T) {
+	for _, first := range []int{0, 1} {
+		ctxs := make([]context.CancelFunc, 2)
+		trackers := make([]*netutil.IdleTracker, 2)
+		for n := range trackers {
+			var ctx context.Context
+			ctx, ctxs[n] = context.WithCancel(context.Background())
+			trackers[n] = netutil.NewIdleTracker(ctx, 1*time.Minute)
+		}
+
+		all := netutil.AllIdle(trackers...)
+		ctxs[first]()
+		<-time.After(5 * time.Millisecond)
+		if isClosed(all) {
+			t.Fatalf("AllIdle fired with only tracker #%d being done", first)
+		}
+		ctxs[1-first]()
+		select {
+		case <-all:
+		case <-time.After(1 * time.Second):
+			t.Fatal("AllIdle did not fire after all trackers are done")
+		}
+	}
+
+	select {
+	case <-netutil.AllIdle():
+	case <-time.After(1 * time.Second):
+		t.Error("AllIdle of no trackers should fire right away")
+	}
+}
+
+func TestAnyIdle(t *testing.T) {
+	for _, first := range []int{0, 1} {
+		ctxs := make([]context.CancelFunc, 2)
+		trackers := make([]*netutil.IdleTracker, 2)
+		for n := range trackers {
+			var ctx context.Context
+			ctx, ctxs[n] = context.WithCancel(context.Background())
+			trackers[n] = netutil.NewIdleTracker(ctx, 1*time.Minute)
+		}
+
+		fired := netutil.AnyIdle(trackers...)
+		<-time.After(5 * time.Millisecond)
+		if isClosed(fired) {
+			t.Fatal("AnyIdle fired before any tracker was done")
+		}
+		ctxs[first]()
+		select {
+		case <-fired:
+		case <-time.After(1 * time.Second):
+			t.Fatalf("AnyIdle did not fire after tracker #%d was done", first)
+		}
+		if isClosed(trackers[1-first].Done()) {
+			t.Error("The other tracker is done, too, but should not be.")
+		}
+		ctxs[1-first]()
+	}
+}
+
+func TestAnyIdleContext(t *testing.T) {
+	a := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	b := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	defer b.Fire(nil)
+	goroutines := runtime.NumGoroutine()
+	ctx := netutil.AnyIdleContext(a, b)
+	if err := ctx.Err(); err != nil {
+		t.Errorf("Err = %v before any tracker is done", err)
+	}
+
+	first := errors.New("first")
+	a.Fire(first)
+	select {
+	case <-ctx.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("AnyIdleContext is not done after a tracker is")
+	}
+	if err := ctx.Err(); err != first {
+		t.Errorf("Err = %v, want that of the tracker done first: %v", err, first)
+	}
+
+	// Those waiting, and the tracker done, are gone.
+	for deadline := time.Now().Add(1 * time.Second); runtime.NumGoroutine() > goroutines-1; {
+		if time.Now().After(deadline) {
+			t.Fatalf("A goroutine has been left behind: %d running, want %d", runtime.NumGoroutine(), goroutines-1)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -1,9 +1,14 @@
 package netutil
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net"
 	"os"
 	"sync"
+	"syscall"
+	"time"
 )
 
 // AcceptedConnection wraps the connection as net.Listener.
@@ -15,45 +20,187 @@ import (
 // connection, remember to have http.Server close it after the first,
 // by setting this HTTP Header:
 //
-//     w.Header().Set("Connection", "close")
+//	w.Header().Set("Connection", "close")
 //
 // Passed to a http.Server, the latter will also return os.ErrClosed
 // signalling its natural end (shutdown). Check for this wherever you
 // expect http.ErrServerClosed to avoid that "false" error.
-func AcceptedConnection(connection *os.File) (net.Listener, error) {
+//
+// The file descriptor gets duplicated, hence the caller retains ownership
+// of connection and can close it right away. Close of the returned
+// listener releases only the duplicates.
+//
+// Deadlines set on the connection work as usual, as it is backed by a
+// non-blocking file descriptor of its own.
+//
+// The returned listener has a method SetAcceptDeadline(time.Time)
+// to bound the wait for the connection to become readable:
+//
+//	ln.(interface{ SetAcceptDeadline(time.Time) }).SetAcceptDeadline(t)
+//
+// It also has CloseContext(context.Context) error, which waits for the
+// connection to be closed before closing the listener,
+// and CloseAfterAccept() error, which waits for it to be accepted.
+// See the AcceptOptions for measuring and capping the wait for Accept.
+//
+// Anything but a socket, such as a FIFO, results in ErrNotSocket;
+// see AcceptedPipe for those. On Windows this returns ErrNotSupported.
+func AcceptedConnection(connection *os.File, opts ...AcceptOption) (net.Listener, error) {
+	c := &acceptedConnection{ctx: context.Background(), handedOver: time.Now()}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	file, err := dupFile(connection)
+	if err != nil {
+		return nil, err
+	}
 	// net.FileListener will provide method 'Addr'.
-	pc, err := net.FileListener(connection)
+	pc, err := fileListener(file)
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
-	return &acceptedConnection{
-		Listener: pc,
-		file:     connection,
-	}, nil
+	if c.exclusive {
+		if c.claimed, err = claimFD(file); err != nil {
+			pc.Close()
+			file.Close()
+			return nil, err
+		}
+	}
+	c.Listener, c.file = pc, file
+	return c, nil
+}
+
+// AcceptedConnectionCtx is AcceptedConnection bound to ctx: once that is
+// done, the listener closes its file descriptors as by Close, and Accept
+// returns ctx.Err(), including any blocked waiting for the connection to
+// become readable or, after the first, to be closed.
+// The connection handed out by Accept is left alone.
+//
+// Closing the listener ends its interest in ctx.
+func AcceptedConnectionCtx(ctx context.Context, connection *os.File, opts ...AcceptOption) (net.Listener, error) {
+	ln, err := AcceptedConnection(connection, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c := ln.(*acceptedConnection)
+	c.ctx = ctx
+	c.stop = make(chan struct{})
+	go c.closeOnCancel(c.stop)
+	return c, nil
+}
+
+// closeOnCancel closes the listener once its context is done,
+// unless stop gets closed first.
+func (c *acceptedConnection) closeOnCancel(stop <-chan struct{}) {
+	select {
+	case <-c.ctx.Done():
+	case <-stop:
+		return
+	}
+	c.mu.Lock() // Any Accept waiting returns, see tailWaitUntilFirstIsDone.
+	defer c.mu.Unlock()
+	if c.permErr == nil {
+		c.permErr = c.ctx.Err()
+		c.closeLocked()
+	}
+}
+
+// AcceptOption configures the listener returned by AcceptedConnection.
+type AcceptOption func(*acceptedConnection) error
+
+// WithAcceptTimeout is like SetAcceptDeadline with a deadline d
+// after the connection has been handed over to AcceptedConnection.
+func WithAcceptTimeout(d time.Duration) AcceptOption {
+	return func(c *acceptedConnection) error {
+		if d <= 0 {
+			return errors.New("netutil: WithAcceptTimeout needs a positive duration")
+		}
+		c.acceptDeadline = c.handedOver.Add(d)
+		return nil
+	}
+}
+
+// WithAcceptObserver has the first Accept call fn with how long it took
+// from the connection being handed over to AcceptedConnection until then,
+// including any wait for it to become readable, and the error if any.
+//
+// Use this to measure the latency of the activation, such as by systemd.
+func WithAcceptObserver(fn func(waited time.Duration, err error)) AcceptOption {
+	return func(c *acceptedConnection) error {
+		if fn == nil {
+			return errors.New("netutil: WithAcceptObserver needs a non-nil func")
+		}
+		c.observer = fn
+		return nil
+	}
 }
 
 // acceptedConnection implements net.Listener.
 type acceptedConnection struct {
-	// Both are backed by the same file descriptor.
+	// Both are backed by duplicates of the caller's file descriptor.
 	net.Listener
 	file *os.File
 
-	mu       sync.Mutex
-	doneChan <-chan struct{}
-	permErr  error
+	accepting      sync.Mutex // Serializes Accept, which waits without holding mu.
+	mu             sync.Mutex
+	pending        net.Conn         // While Accept waits for it to become readable.
+	conn           *cascadingCloser // Once handed out.
+	closeAfter     bool             // Set by CloseAfterAccept.
+	doneChan       <-chan struct{}
+	permErr        error
+	acceptDeadline time.Time
+
+	handedOver time.Time
+	observer   func(waited time.Duration, err error) // Cleared once called.
+
+	ctx  context.Context // See AcceptedConnectionCtx.
+	stop chan struct{}   // Closed along with the listener.
+
+	exclusive bool        // See WithExclusiveFD.
+	claimed   os.FileInfo // Released along with the listener.
+}
+
+// SetAcceptDeadline makes the first Accept wait until the connection is
+// readable, but no longer than t. Past that, Accept returns a net.Error
+// that reports a timeout, and so will any subsequent call.
+// A zero value for t disables this, which is the default.
+//
+// Don't use this with protocols in which the server speaks first.
+func (c *acceptedConnection) SetAcceptDeadline(t time.Time) {
+	c.mu.Lock()
+	c.acceptDeadline = t
+	if c.pending != nil { // Applies to the wait in progress as well.
+		c.pending.SetReadDeadline(t)
+	}
+	c.mu.Unlock()
 }
 
 // Accept implements net.Listener.
 // Only the first call will deliver, all subsequent will block
 // until it is closed.
-func (c *acceptedConnection) Accept() (net.Conn, error) {
+func (c *acceptedConnection) Accept() (_ net.Conn, err error) {
 	// The FileConn is gotten here for its error "fcntl: too many open files"
 	// that can be used to back off.
+	var observe func(time.Duration, error)
+	defer func() {
+		if observe != nil { // Outside the lock, in case it calls Close.
+			observe(time.Since(c.handedOver), err)
+		}
+	}()
+	c.accepting.Lock()
+	defer c.accepting.Unlock()
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	observe, c.observer = c.observer, nil
 	if c.permErr != nil {
 		return nil, c.permErr
 	}
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
 	if c.doneChan != nil {
 		return c.tailWaitUntilFirstIsDone()
 	}
@@ -63,27 +210,161 @@ func (c *acceptedConnection) Accept() (net.Conn, error) {
 		c.permErr = err
 		return nil, err
 	}
+	if !c.acceptDeadline.IsZero() {
+		// Set here, for Close to be able to interrupt the wait right away.
+		if err := conn.SetReadDeadline(c.acceptDeadline); err != nil {
+			conn.Close()
+			c.permErr = err
+			return nil, err
+		}
+		c.pending = conn
+		c.mu.Unlock()
+		err := awaitReadable(c.ctx, conn)
+		c.mu.Lock()
+		c.pending = nil
+		conn.SetReadDeadline(time.Time{})
+		if c.permErr != nil { // Closed meanwhile.
+			err = c.permErr
+		}
+		if err != nil {
+			conn.Close()
+			c.permErr = err
+			return nil, err
+		}
+	}
 
 	sharedBlockingChan := make(chan struct{})
 	c.doneChan = sharedBlockingChan
-	return &cascadingCloser{Conn: conn, closeChan: sharedBlockingChan}, nil
+	c.conn = &cascadingCloser{Conn: conn, closeChan: sharedBlockingChan}
+	if c.closeAfter {
+		c.closeLocked()
+	}
+	return c.conn, nil
 }
 
 func (c *acceptedConnection) tailWaitUntilFirstIsDone() (net.Conn, error) {
-	<-c.doneChan
-	return nil, os.ErrClosed
+	select {
+	case <-c.doneChan:
+		return nil, os.ErrClosed
+	case <-c.ctx.Done():
+		return nil, c.ctx.Err()
+	}
 }
 
+// awaitReadable blocks until there is something to read from conn,
+// which includes its end, its read deadline has passed, or ctx is done.
+func awaitReadable(ctx context.Context, conn net.Conn) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil // Cannot wait, which is no different from not waiting.
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if ctx.Done() != nil {
+		quit, exited := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(exited)
+			select {
+			case <-ctx.Done():
+				conn.SetReadDeadline(time.Unix(1, 0)) // Interrupts the wait.
+			case <-quit:
+			}
+		}()
+		defer func() {
+			close(quit)
+			<-exited
+		}()
+	}
+
+	var polled bool
+	err = rc.Read(func(uintptr) bool {
+		// The first call is before any waiting, the second after it.
+		done := polled
+		polled = true
+		return done
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil && err != nil {
+		return ctxErr
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return acceptTimeoutError{}
+	}
+	return err
+}
+
+// acceptTimeoutError is returned by Accept if its deadline has passed.
+//
+// Unlike os.ErrDeadlineExceeded it is not temporary,
+// which would have http.Server retry indefinitely.
+type acceptTimeoutError struct{}
+
+func (acceptTimeoutError) Error() string   { return "netutil: accept deadline exceeded" }
+func (acceptTimeoutError) Timeout() bool   { return true }
+func (acceptTimeoutError) Temporary() bool { return false }
+
 // Close implements net.Listener.
 func (c *acceptedConnection) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	return c.closeLocked()
+}
+
+func (c *acceptedConnection) closeLocked() error {
 	if c.permErr == nil {
 		c.permErr = os.ErrClosed
 	}
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+	if c.claimed != nil {
+		releaseFD(c.claimed)
+		c.claimed = nil
+	}
+	if c.pending != nil {
+		c.pending.SetReadDeadline(time.Unix(1, 0)) // Interrupts Accept's wait.
+	}
+	c.Listener.Close()
 	return c.file.Close()
 }
 
+// CloseAfterAccept is like Close, but if Accept has not been called yet, lets
+// the next call still deliver the connection, so that it doesn't get lost in
+// a race with shutdown. Any call after that one returns os.ErrClosed.
+func (c *acceptedConnection) CloseAfterAccept() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.doneChan != nil || c.permErr != nil {
+		return c.closeLocked()
+	}
+	c.closeAfter = true
+	return nil
+}
+
+// CloseContext is like Close, but first waits for the connection handed out
+// by Accept, if any, to be closed, so that any response in flight can finish.
+// Once ctx is done the connection gets closed forcibly,
+// and an error wrapping ctx.Err() is returned.
+func (c *acceptedConnection) CloseContext(ctx context.Context) error {
+	c.mu.Lock()
+	conn, done := c.conn, c.doneChan
+	c.mu.Unlock()
+	if conn == nil {
+		return c.Close()
+	}
+
+	select {
+	case <-done:
+		return c.Close()
+	case <-ctx.Done():
+	}
+	conn.Close() // Unblocks any Accept after the first, which holds the lock.
+	c.Close()
+	return fmt.Errorf("netutil: closed the connection forcibly: %w", ctx.Err())
+}
+
 // cascadingCloser is used to unblock any receivers listening to the given channel.
 type cascadingCloser struct {
 	net.Conn
@@ -1,20 +1,30 @@
 // This file is released into the public domain.
 
+//go:build !windows
+// +build !windows
+
+// Windows cannot pass file descriptors,
+// which makes AcceptedConnection unavailable there.
+
 package netutil_test
 
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"runtime"
+	"strconv"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
-	"github.com/coreos/go-systemd/v22/activation"
 	netutil "github.com/wmark/go.netutil"
 )
 
@@ -24,8 +34,8 @@ func ExampleAcceptedConnection() {
 
 	var ln net.Listener
 	if _, acceptedConn := os.LookupEnv("LISTEN_FDS"); acceptedConn {
-		fds := activation.Files(true) // Accept=true makes that connections.
-		if len(fds) < 1 {
+		fds, err := netutil.ActivatedFiles() // With Accept=yes these are connections.
+		if err != nil || len(fds) < 1 {
 			log.Fatalf("Conn activated, but not been given any FD")
 		}
 		ln, _ = netutil.AcceptedConnection(fds[0])
@@ -64,7 +74,8 @@ func TestAcceptedConnection(t *testing.T) {
 		// Accept a single connection to get its FD, as if systemd passed it.
 		c, err := listener.Accept()
 		if err != nil {
-			t.Fatalf("net.Accept: %v\n", err)
+			t.Errorf("net.Accept: %v\n", err)
+			return
 		}
 		tcpConn, ok := c.(*net.TCPConn)
 		f, _ := tcpConn.File()
@@ -73,7 +84,8 @@ func TestAcceptedConnection(t *testing.T) {
 		// Now emulate the typical use-case.
 		ln, err = netutil.AcceptedConnection(f)
 		if err != nil {
-			t.Fatalf("netutil.AcceptedConnection: %v\n", err)
+			t.Errorf("netutil.AcceptedConnection: %v\n", err)
+			return
 		}
 
 		mux := http.NewServeMux()
@@ -87,7 +99,7 @@ func TestAcceptedConnection(t *testing.T) {
 		case nil, http.ErrServerClosed, os.ErrClosed:
 			return
 		default:
-			t.Fatalf("server.Serve: %v", err)
+			t.Errorf("server.Serve: %v", err)
 		}
 	}()
 
@@ -118,3 +130,541 @@ func TestAcceptedConnection(t *testing.T) {
 		t.Errorf("The connection is closed, but Accept's error doesn't reflect that. Got: %v\n", err)
 	}
 }
+
+// acceptedFile emulates systemd passing an accepted connection.
+// The client end is returned alongside.
+func acceptedFile(t *testing.T) (*os.File, net.Conn) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v\n", err)
+	}
+	defer listener.Close()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v\n", err)
+	}
+	c, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("net.Accept: %v\n", err)
+	}
+	defer c.Close()
+	f, err := c.(*net.TCPConn).File()
+	if err != nil {
+		t.Fatalf("File: %v\n", err)
+	}
+	return f, client
+}
+
+func TestAcceptDeadline(t *testing.T) {
+	f, client := acceptedFile(t)
+	defer client.Close()
+	ln, err := netutil.AcceptedConnection(f)
+	if err != nil {
+		t.Fatalf("netutil.AcceptedConnection: %v\n", err)
+	}
+	defer ln.Close()
+
+	// The client never writes anything.
+	ln.(interface{ SetAcceptDeadline(time.Time) }).SetAcceptDeadline(time.Now().Add(50 * time.Millisecond))
+	conn, err := ln.Accept()
+	if err == nil {
+		conn.Close()
+		t.Fatal("Accept should've timed out, but delivered a connection")
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() || ne.Temporary() {
+		t.Errorf("Accept should return a permanent timeout error, got: %v", err)
+	}
+	if _, err2 := ln.Accept(); err2 != err {
+		t.Errorf("A subsequent Accept should return the same error, got: %v", err2)
+	}
+}
+
+func TestAcceptDeadlineMet(t *testing.T) {
+	f, client := acceptedFile(t)
+	defer client.Close()
+	ln, err := netutil.AcceptedConnection(f)
+	if err != nil {
+		t.Fatalf("netutil.AcceptedConnection: %v\n", err)
+	}
+	defer ln.Close()
+
+	ln.(interface{ SetAcceptDeadline(time.Time) }).SetAcceptDeadline(time.Now().Add(2 * time.Second))
+	go client.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept returned an error, but the client has sent something: %v", err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 3)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "GET" {
+		t.Errorf("Failed to read what the client sent: %q, %v", buf, err)
+	}
+}
+
+func TestAcceptDeadlineClose(t *testing.T) {
+	f, client := acceptedFile(t)
+	defer client.Close() // Which doesn't send anything.
+	defer f.Close()
+	ln, err := netutil.AcceptedConnection(f, netutil.WithAcceptTimeout(1*time.Hour))
+	if err != nil {
+		t.Fatalf("netutil.AcceptedConnection: %v\n", err)
+	}
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		accepted <- err
+	}()
+	<-time.After(30 * time.Millisecond) // For Accept to wait.
+	closed := make(chan struct{})
+	go func() {
+		ln.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close is blocked by Accept waiting for the connection to become readable")
+	}
+	select {
+	case err := <-accepted:
+		if err != os.ErrClosed {
+			t.Errorf("Accept returned %v, want os.ErrClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close has not interrupted Accept")
+	}
+}
+
+func TestAcceptObserver(t *testing.T) {
+	f, client := acceptedFile(t)
+	defer client.Close()
+
+	var (
+		calls  int
+		waited time.Duration
+		seen   error
+	)
+	before := time.Now()
+	ln, err := netutil.AcceptedConnection(f,
+		netutil.WithAcceptTimeout(2*time.Second),
+		netutil.WithAcceptObserver(func(d time.Duration, err error) {
+			calls++
+			waited, seen = d, err
+		}))
+	if err != nil {
+		t.Fatalf("netutil.AcceptedConnection: %v\n", err)
+	}
+	defer ln.Close()
+
+	time.AfterFunc(50*time.Millisecond, func() { client.Write([]byte("GET")) })
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept returned an error, but the client has sent something: %v", err)
+	}
+	defer conn.Close()
+	if calls != 1 || seen != nil {
+		t.Fatalf("The observer should have been called once without an error, got: %d, %v", calls, seen)
+	}
+	if waited < 50*time.Millisecond || waited > time.Since(before) {
+		t.Errorf("Implausible wait reported: %v", waited)
+	}
+}
+
+func TestAcceptObserverTimeout(t *testing.T) {
+	f, client := acceptedFile(t)
+	defer client.Close()
+
+	var seen error
+	ln, err := netutil.AcceptedConnection(f,
+		netutil.WithAcceptTimeout(50*time.Millisecond),
+		netutil.WithAcceptObserver(func(_ time.Duration, err error) { seen = err }))
+	if err != nil {
+		t.Fatalf("netutil.AcceptedConnection: %v\n", err)
+	}
+	defer ln.Close()
+
+	if _, err := ln.Accept(); err == nil || err != seen {
+		t.Errorf("The observer should have seen the timeout Accept returned, got: %v, %v", seen, err)
+	}
+	if _, err := netutil.AcceptedConnection(f, netutil.WithAcceptTimeout(0)); err == nil {
+		t.Error("A non-positive accept timeout should have been rejected")
+	}
+}
+
+func TestAcceptedConnectionDeadlines(t *testing.T) {
+	f, client := acceptedFile(t)
+	defer client.Close()
+	ln, err := netutil.AcceptedConnection(f)
+	if err != nil {
+		t.Fatalf("netutil.AcceptedConnection: %v\n", err)
+	}
+	defer ln.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	defer conn.Close()
+
+	// The client neither writes nor reads anything.
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(50 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("Read should've timed out, got: %v", err)
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("Read took %v to time out, the read deadline is not honored", took)
+	}
+
+	start = time.Now()
+	conn.SetWriteDeadline(start.Add(50 * time.Millisecond))
+	chunk := make([]byte, 64*1024)
+	for err = nil; err == nil; {
+		_, err = conn.Write(chunk) // Until all buffers are full.
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("Write has not timed out, the write deadline is not honored")
+		}
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("Write should've timed out, got: %v", err)
+	}
+}
+
+// socketOf returns what the file descriptor of f refers to,
+// such as "socket:[1234]", for use with fdsOf.
+func socketOf(t *testing.T, f *os.File) (target string) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn: %v", err)
+	}
+	rc.Control(func(fd uintptr) {
+		target, err = os.Readlink("/proc/self/fd/" + strconv.Itoa(int(fd)))
+	})
+	if err != nil {
+		t.Skipf("Cannot tell what file descriptors refer to: %v", err)
+	}
+	return target
+}
+
+// fdsOf lists this process' file descriptors that refer to target,
+// unaffected by any others being opened or closed meanwhile.
+func fdsOf(t *testing.T, target string) []string {
+	entries, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("Cannot list open file descriptors: %v", err)
+	}
+	var fds []string
+	for _, e := range entries {
+		if link, _ := os.Readlink("/proc/self/fd/" + e.Name()); link == target {
+			fds = append(fds, e.Name())
+		}
+	}
+	return fds
+}
+
+func TestAcceptedConnectionOwnership(t *testing.T) {
+	f, client := acceptedFile(t)
+	defer client.Close()
+	socket := socketOf(t, f)
+
+	ln, err := netutil.AcceptedConnection(f)
+	if err != nil {
+		t.Fatalf("netutil.AcceptedConnection: %v\n", err)
+	}
+	f.Close() // The caller can let go right away.
+
+	go client.Write([]byte("ping"))
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept after closing the original file: %v", err)
+	}
+	buf := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("Read after closing the original file: %v", err)
+	}
+	if fds := fdsOf(t, socket); len(fds) == 0 {
+		t.Fatal("Neither the listener nor the connection hold a file descriptor of the socket")
+	}
+	conn.Close()
+	ln.Close()
+
+	if fds := fdsOf(t, socket); len(fds) != 0 {
+		t.Errorf("File descriptors %v of the socket are still open", fds)
+	}
+}
+
+func TestAcceptedConnectionLeavesOriginal(t *testing.T) {
+	f, client := acceptedFile(t)
+	defer client.Close()
+	defer f.Close()
+
+	ln, err := netutil.AcceptedConnection(f)
+	if err != nil {
+		t.Fatalf("netutil.AcceptedConnection: %v\n", err)
+	}
+	ln.Close()
+
+	if _, err := f.Stat(); err != nil {
+		t.Errorf("Closing the listener has affected the original file: %v", err)
+	}
+}
+
+type contextCloser interface {
+	CloseContext(context.Context) error
+}
+
+func TestCloseContextGraceful(t *testing.T) {
+	f, client := acceptedFile(t)
+	defer client.Close()
+	ln, err := netutil.AcceptedConnection(f)
+	if err != nil {
+		t.Fatalf("netutil.AcceptedConnection: %v\n", err)
+	}
+	f.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+
+	go func() {
+		<-time.After(20 * time.Millisecond)
+		conn.Write([]byte("bye"))
+		conn.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := ln.(contextCloser).CloseContext(ctx); err != nil {
+		t.Errorf("CloseContext returned an error, although the connection got closed in time: %v", err)
+	}
+	if took := time.Since(start); took < 20*time.Millisecond {
+		t.Errorf("CloseContext has not waited for the connection, took: %v", took)
+	}
+	buf := make([]byte, 3)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(client, buf); err != nil || string(buf) != "bye" {
+		t.Errorf("The response has been cut off: %q, %v", buf, err)
+	}
+	if _, err := ln.Accept(); err != os.ErrClosed {
+		t.Errorf("After CloseContext, Accept should return os.ErrClosed, got: %v", err)
+	}
+}
+
+func TestCloseContextForced(t *testing.T) {
+	f, client := acceptedFile(t)
+	defer client.Close()
+	ln, err := netutil.AcceptedConnection(f)
+	if err != nil {
+		t.Fatalf("netutil.AcceptedConnection: %v\n", err)
+	}
+	f.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	defer conn.Close()
+
+	// Like http.Server, be blocked in Accept.
+	tailAccept := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		tailAccept <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	err = ln.(contextCloser).CloseContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CloseContext should have returned a wrapped context.DeadlineExceeded, got: %v", err)
+	}
+	select {
+	case <-tailAccept:
+	case <-time.After(1 * time.Second):
+		t.Error("The pending Accept is still blocked")
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("The connection has not been closed, the client reads: %v", err)
+	}
+}
+
+func TestCloseAfterAccept(t *testing.T) {
+	f, client := acceptedFile(t)
+	defer client.Close()
+	ln, err := netutil.AcceptedConnection(f)
+	if err != nil {
+		t.Fatalf("netutil.AcceptedConnection: %v\n", err)
+	}
+	f.Close()
+
+	if err := ln.(interface{ CloseAfterAccept() error }).CloseAfterAccept(); err != nil {
+		t.Fatalf("CloseAfterAccept: %v", err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("The pending connection has not been delivered: %v", err)
+	}
+	defer conn.Close()
+	if _, err := ln.Accept(); err != os.ErrClosed {
+		t.Errorf("Once delivered, Accept should return os.ErrClosed right away, got: %v", err)
+	}
+
+	go client.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Errorf("The delivered connection is not usable: %v", err)
+	}
+}
+
+func TestAcceptedConnectionCtxCancel(t *testing.T) {
+	f, client := acceptedFile(t)
+	defer client.Close()
+	socket := socketOf(t, f)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ln, err := netutil.AcceptedConnectionCtx(ctx, f)
+	if err != nil {
+		t.Fatalf("netutil.AcceptedConnectionCtx: %v\n", err)
+	}
+	f.Close()
+	dups := fdsOf(t, socket) // The listener's.
+	if len(dups) == 0 {
+		t.Fatal("The listener holds no file descriptor of the socket")
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	defer conn.Close()
+
+	tailAccept := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		tailAccept <- err
+	}()
+	cancel()
+	select {
+	case err := <-tailAccept:
+		if err != context.Canceled {
+			t.Errorf("The pending Accept returned %v, want context.Canceled", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("The pending Accept is still blocked")
+	}
+	if _, err := ln.Accept(); err != context.Canceled {
+		t.Errorf("Accept after the cancellation returned %v, want context.Canceled", err)
+	}
+
+	// The original is closed, the one of the accepted connection still open.
+	for deadline := time.Now().Add(1 * time.Second); ; {
+		var open []string
+		for _, fd := range dups {
+			if link, _ := os.Readlink("/proc/self/fd/" + fd); link == socket {
+				open = append(open, fd)
+			}
+		}
+		if len(open) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("The listener's file descriptors %v have not been closed", open)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if fds := fdsOf(t, socket); len(fds) != 1 {
+		t.Errorf("Expected only the accepted connection's file descriptor, have: %v", fds)
+	}
+	go client.Write([]byte("ping"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Errorf("The accepted connection should have been left alone: %v", err)
+	}
+}
+
+func TestAcceptedConnectionCtxCancelWhileAwaiting(t *testing.T) {
+	f, client := acceptedFile(t)
+	defer client.Close() // Which doesn't send anything.
+	defer f.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	ln, err := netutil.AcceptedConnectionCtx(ctx, f, netutil.WithAcceptTimeout(1*time.Hour))
+	if err != nil {
+		t.Fatalf("netutil.AcceptedConnectionCtx: %v\n", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		accepted <- err
+	}()
+	select {
+	case err := <-accepted:
+		if err != context.DeadlineExceeded {
+			t.Errorf("Accept returned %v, want context.DeadlineExceeded", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Accept is still waiting for the connection to become readable")
+	}
+}
+
+func TestAcceptedConnectionCtxClose(t *testing.T) {
+	f, client := acceptedFile(t)
+	defer client.Close()
+	defer f.Close()
+	ctx, cancel := context.WithCancel(context.Background()) // Never cancelled before the end.
+	defer cancel()
+	goroutines := runtime.NumGoroutine()
+
+	ln, err := netutil.AcceptedConnectionCtx(ctx, f)
+	if err != nil {
+		t.Fatalf("netutil.AcceptedConnectionCtx: %v\n", err)
+	}
+	if err := ln.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if _, err := ln.Accept(); err != os.ErrClosed {
+		t.Errorf("Accept after Close returned %v, want os.ErrClosed", err)
+	}
+	for deadline := time.Now().Add(1 * time.Second); runtime.NumGoroutine() > goroutines; {
+		if time.Now().After(deadline) {
+			t.Fatalf("A goroutine has been left behind: %d running, want %d", runtime.NumGoroutine(), goroutines)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWithExclusiveFD(t *testing.T) {
+	f, client := acceptedFile(t)
+	defer client.Close()
+	defer f.Close()
+	ln, err := netutil.AcceptedConnection(f, netutil.WithExclusiveFD())
+	if err != nil {
+		t.Fatalf("netutil.AcceptedConnection: %v", err)
+	}
+
+	// Another descriptor of the same socket is no different.
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatalf("syscall.Dup: %v", err)
+	}
+	other := os.NewFile(uintptr(fd), "other")
+	defer other.Close()
+	for _, g := range []*os.File{f, other} {
+		if _, err := netutil.AcceptedConnection(g, netutil.WithExclusiveFD()); err != netutil.ErrFDInUse {
+			t.Errorf("Wrapping the socket a second time = %v, want: %v", err, netutil.ErrFDInUse)
+		}
+	}
+
+	ln.Close()
+	ln, err = netutil.AcceptedConnection(other, netutil.WithExclusiveFD())
+	if err != nil {
+		t.Fatalf("The socket has not been released along with the listener: %v", err)
+	}
+	ln.Close()
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"net"
+	"net/http"
+)
+
+// ActiveConns returns how many connections are being tracked.
+func (t *IdleTracker) ActiveConns() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.dangling.len()
+}
+
+// IsTracked reports whether the connection is being tracked, which is
+// from http.StateNew or http.StateActive until http.StateIdle or
+// http.StateClosed, see WithKeepAliveCounts for the former.
+// Hijacked connections are no longer tracked.
+//
+// Use this to verify that ConnState has been wired correctly.
+func (t *IdleTracker) IsTracked(c net.Conn) bool {
+	key := t.keyOf(c)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, found := t.dangling.get(key)
+	return found
+}
+
+// ShutdownWouldBlock tells whether server.Shutdown would have to wait,
+// because a tracked connection has a request in flight or is new, or
+// a request counted by Handler or BeginRequest has not ended yet.
+// Connections idling between requests, see WithKeepAliveCounts, don't block.
+//
+// Use this to decide whether to delay maintenance, such as a deployment.
+func (t *IdleTracker) ShutdownWouldBlock() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	blocks := t.inflight > 0
+	t.dangling.each(func(_ interface{}, ci *connInfo) bool {
+		blocks = blocks || ci.state == http.StateNew || ci.state == http.StateActive
+		return !blocks
+	})
+	return blocks
+}
+
+// ForEachConn calls fn for every tracked connection, such as to send them
+// a GOAWAY or close frame ahead of a shutdown.
+//
+// fn gets called on a snapshot, hence without any lock held, and can take
+// its time. Connections could have been closed in the meantime.
+func (t *IdleTracker) ForEachConn(fn func(net.Conn)) {
+	t.mu.RLock()
+	conns := t.dangling.conns()
+	t.mu.RUnlock()
+
+	for _, c := range conns {
+		fn(c)
+	}
+}
+
+// CloseIdleConns closes the keep-alive connections that are tracked
+// WithKeepAliveCounts, but sit idle between requests, and stops tracking them.
+// Connections with a request in flight are left alone.
+// It returns how many have been closed.
+//
+// Use this once done, for server.Shutdown to not have to wait for them.
+func (t *IdleTracker) CloseIdleConns() int {
+	t.mu.Lock()
+	var idle []net.Conn
+	var keys []interface{}
+	t.dangling.each(func(key interface{}, ci *connInfo) bool {
+		if ci.state == http.StateIdle {
+			idle = append(idle, ci.conn)
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		t.untrack(key)
+	}
+	if len(idle) > 0 && t.busy() == 0 {
+		t.startIdling()
+	}
+	t.mu.Unlock()
+
+	for _, c := range idle {
+		c.Close()
+	}
+	return len(idle)
+}
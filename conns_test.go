@@ -0,0 +1,145 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestForEachConn(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	netutiltest.DriveConnState(i, []http.ConnState{
+		http.StateNew, http.StateActive,
+		http.StateNew,
+		http.StateNew, http.StateActive, http.StateClosed,
+	})
+	if got := i.ActiveConns(); got != 2 {
+		t.Fatalf("ActiveConns = %d, want 2", got)
+	}
+
+	seen := make(map[net.Conn]int)
+	i.ForEachConn(func(c net.Conn) {
+		seen[c]++
+		// Must not deadlock.
+		i.ConnState(c, http.StateClosed)
+	})
+	if len(seen) != 2 {
+		t.Errorf("ForEachConn called fn for %d distinct connections, want 2", len(seen))
+	}
+	for c, calls := range seen {
+		if calls != 1 {
+			t.Errorf("ForEachConn called fn %d times for %v", calls, c)
+		}
+	}
+	if got := i.ActiveConns(); got != 0 {
+		t.Errorf("After closing all, ActiveConns = %d", got)
+	}
+}
+
+func TestCloseIdleConns(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithKeepAliveCounts())
+	busy, idle, quiet := &closeCountingConn{}, &closeCountingConn{}, &closeCountingConn{}
+	for _, c := range []net.Conn{busy, idle, quiet} {
+		i.ConnState(c, http.StateNew)
+		i.ConnState(c, http.StateActive)
+	}
+	i.ConnState(idle, http.StateIdle)
+	i.ConnState(quiet, http.StateIdle)
+	if n := i.ActiveConns(); n != 3 {
+		t.Fatalf("Idle keep-alive connections should count, got: %d", n)
+	}
+
+	if n := i.CloseIdleConns(); n != 2 {
+		t.Errorf("CloseIdleConns = %d, want 2", n)
+	}
+	if busy.closed != 0 || idle.closed != 1 || quiet.closed != 1 {
+		t.Errorf("Only idle connections should have been closed, got: %d %d %d", busy.closed, idle.closed, quiet.closed)
+	}
+	if n := i.ActiveConns(); n != 1 {
+		t.Errorf("The connection with a request in flight should be left, got: %d", n)
+	}
+
+	i.ConnState(busy, http.StateIdle)
+	if _, ok := i.Deadline(); ok {
+		t.Error("An idle keep-alive connection should keep the tracker busy")
+	}
+	if n := i.CloseIdleConns(); n != 1 {
+		t.Errorf("CloseIdleConns = %d, want 1", n)
+	}
+	if _, ok := i.Deadline(); !ok {
+		t.Error("After closing the last connection the tracker should be idling")
+	}
+}
+
+func TestIsTracked(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		opts          []netutil.Option
+		states        []http.ConnState
+		trackedAtLast bool
+	}{
+		{"new", nil, []http.ConnState{http.StateNew}, true},
+		{"active", nil, []http.ConnState{http.StateNew, http.StateActive}, true},
+		{"idle", nil, []http.ConnState{http.StateNew, http.StateActive, http.StateIdle}, false},
+		{"active again", nil, []http.ConnState{http.StateNew, http.StateActive, http.StateIdle, http.StateActive}, true},
+		{"closed", nil, []http.ConnState{http.StateNew, http.StateActive, http.StateClosed}, false},
+		{"hijacked", nil, []http.ConnState{http.StateNew, http.StateActive, http.StateHijacked}, false},
+		{"keep-alive idle", []netutil.Option{netutil.WithKeepAliveCounts()},
+			[]http.ConnState{http.StateNew, http.StateActive, http.StateIdle}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, tc.opts...)
+			conn := &netutiltest.Conn{}
+			if i.IsTracked(conn) {
+				t.Fatal("IsTracked = true before any state change")
+			}
+			for _, state := range tc.states {
+				i.ConnState(conn, state)
+			}
+			if got := i.IsTracked(conn); got != tc.trackedAtLast {
+				t.Errorf("IsTracked = %v, want %v", got, tc.trackedAtLast)
+			}
+			if i.IsTracked(&netutiltest.Conn{}) {
+				t.Error("IsTracked = true for a connection never seen")
+			}
+		})
+	}
+}
+
+func TestShutdownWouldBlock(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithKeepAliveCounts())
+	if i.ShutdownWouldBlock() {
+		t.Error("Without any connection Shutdown would not block")
+	}
+
+	a, b := &netutiltest.Conn{}, &netutiltest.Conn{}
+	i.ConnState(a, http.StateNew)
+	i.ConnState(a, http.StateActive)
+	i.ConnState(a, http.StateIdle)
+	i.ConnState(b, http.StateNew)
+	i.ConnState(b, http.StateActive)
+	if !i.ShutdownWouldBlock() {
+		t.Error("With a request in flight Shutdown would block")
+	}
+
+	i.ConnState(b, http.StateIdle)
+	if n := i.ActiveConns(); n != 2 {
+		t.Fatalf("Both connections should be kept track of, got: %d", n)
+	}
+	if i.ShutdownWouldBlock() {
+		t.Error("Connections that merely idle don't block Shutdown")
+	}
+
+	end := i.BeginRequest()
+	if !i.ShutdownWouldBlock() {
+		t.Error("With a request through BeginRequest in flight Shutdown would block")
+	}
+	end()
+}
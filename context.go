@@ -0,0 +1,78 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"context"
+	"time"
+)
+
+// contextKey is a value for use with context.WithValue.
+// It's used as a pointer so it fits in an interface{} without allocation.
+type contextKey struct {
+	name string
+}
+
+func (k *contextKey) String() string { return "netutil context value " + k.name }
+
+// ShutdownReasonKey is the context key under which to store a string
+// that tells why the service is shutting down, such as "deploy" or "scale-down".
+// Set it on the parent before cancelling that, with context.WithValue.
+var ShutdownReasonKey = &contextKey{"shutdown-reason"}
+
+// ShutdownReason returns the string stored under ShutdownReasonKey
+// anywhere in the chain of contexts, including through an IdleTracker.
+// It's empty if there is none.
+func ShutdownReason(ctx context.Context) string {
+	reason, _ := ctx.Value(ShutdownReasonKey).(string)
+	return reason
+}
+
+// IdleTrackerKey is the context key under which an IdleTracker
+// can be found by itself, even if wrapped by other contexts.
+var IdleTrackerKey = &contextKey{"idle-tracker"}
+
+// FromContext returns the IdleTracker nearest in the chain of contexts.
+// Use this in middleware to locate the tracker behind a request's context,
+// which would be hidden by any context.WithValue.
+func FromContext(ctx context.Context) (*IdleTracker, bool) {
+	t, ok := ctx.Value(IdleTrackerKey).(*IdleTracker)
+	return t, ok
+}
+
+// IdleOnlyContext returns a context that is done once the tracker fires
+// on account of having been idle, its hard deadline, or Fire, but never
+// if the tracker is done because its parent is. Use it for cleanup that
+// is specific to idling, and must not be cut short by a parent cancelled
+// for other reasons. Values are looked up through the tracker.
+func (t *IdleTracker) IdleOnlyContext() context.Context {
+	return idleOnlyContext{t}
+}
+
+// idleOnlyContext implements context.Context, see IdleOnlyContext.
+type idleOnlyContext struct {
+	t *IdleTracker
+}
+
+func (idleOnlyContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c idleOnlyContext) Done() <-chan struct{} {
+	return c.t.idled
+}
+
+func (c idleOnlyContext) Err() error {
+	select {
+	case <-c.t.idled:
+		return c.t.Err()
+	default:
+		return nil
+	}
+}
+
+func (c idleOnlyContext) Value(key interface{}) interface{} {
+	return c.t.Value(key)
+}
@@ -0,0 +1,81 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func TestShutdownReason(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	i := netutil.NewIdleTracker(ctx, 1*time.Minute)
+	if got := netutil.ShutdownReason(i); got != "" {
+		t.Errorf("Without any reason set, got: %q", got)
+	}
+
+	parent := context.WithValue(ctx, netutil.ShutdownReasonKey, "deploy")
+	i = netutil.NewIdleTracker(parent, 1*time.Minute)
+	derived, cancelDerived := context.WithTimeout(i, 1*time.Minute)
+	defer cancelDerived()
+	if got := netutil.ShutdownReason(derived); got != "deploy" {
+		t.Errorf("ShutdownReason = %q, want \"deploy\"", got)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if _, ok := netutil.FromContext(context.Background()); ok {
+		t.Error("FromContext found a tracker where there is none")
+	}
+
+	outer := netutil.NewIdleTracker(context.Background(), 1*time.Minute)
+	inner := netutil.NewIdleTracker(context.WithValue(outer, "key", "foo"), 1*time.Minute)
+
+	var ctx context.Context = inner
+	ctx = context.WithValue(ctx, "a", 1)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx = context.WithValue(ctx, "b", 2)
+
+	got, ok := netutil.FromContext(ctx)
+	if !ok || got != inner {
+		t.Errorf("FromContext should have found the nearest tracker, got: %v, %v", got, ok)
+	}
+	if got, _ := netutil.FromContext(context.WithValue(outer, "c", 3)); got != outer {
+		t.Errorf("FromContext should have found the outer tracker, got: %v", got)
+	}
+	if ctx.Value("key") != "foo" {
+		t.Error("Values of the parent have been lost")
+	}
+}
+
+func TestIdleOnlyContext(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	i := netutil.NewIdleTracker(parent, 1*time.Hour)
+	ctx := i.IdleOnlyContext()
+	cancel()
+	<-i.Done()
+	select {
+	case <-ctx.Done():
+		t.Fatal("The idle-only context is done on account of the parent")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := ctx.Err(); err != nil {
+		t.Errorf("Err = %v, want none", err)
+	}
+
+	i = netutil.NewIdleTracker(context.Background(), 20*time.Millisecond)
+	ctx = i.IdleOnlyContext()
+	select {
+	case <-ctx.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("The idle-only context is not done although the tracker went idle")
+	}
+	if err := ctx.Err(); err != context.DeadlineExceeded {
+		t.Errorf("Err = %v, want: %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"context"
+	"net"
+)
+
+// ContextListener returns a net.Listener of which the connections carry a
+// context derived from base, to be retrieved by ConnContext. It gets
+// cancelled once base is done, such as an IdleTracker, or the connection
+// has been closed. Use this to have handlers of any protocol bail out.
+func ContextListener(ln net.Listener, base context.Context) net.Listener {
+	return &contextListener{Listener: ln, base: base}
+}
+
+// ConnContext returns the context of a connection accepted by a listener
+// from ContextListener, else context.Background().
+//
+// Pass the connection as it has been accepted, as any wrapper hides it.
+func ConnContext(c net.Conn) context.Context {
+	if cc, ok := c.(*contextConn); ok {
+		return cc.ctx
+	}
+	return context.Background()
+}
+
+// contextListener implements net.Listener.
+type contextListener struct {
+	net.Listener
+	base context.Context
+}
+
+// Accept implements net.Listener.
+func (l *contextListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(l.base)
+	return &contextConn{Conn: c, ctx: ctx, cancel: cancel}, nil
+}
+
+// contextConn cancels its context on Close.
+type contextConn struct {
+	net.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Close implements net.Conn.
+func (c *contextConn) Close() error {
+	c.cancel()
+	return c.Conn.Close()
+}
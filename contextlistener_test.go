@@ -0,0 +1,62 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestContextListener(t *testing.T) {
+	parentCtx, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+	i := netutil.NewIdleTracker(parentCtx, 1*time.Hour)
+
+	inner, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ln := netutil.ContextListener(inner, i)
+	defer ln.Close()
+
+	accept := func() net.Conn {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("net.Dial: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		c, err := ln.Accept()
+		if err != nil {
+			t.Fatalf("Accept: %v", err)
+		}
+		return c
+	}
+	a, b, closed := accept(), accept(), accept()
+	defer a.Close()
+	defer b.Close()
+
+	closed.Close()
+	if err := netutil.ConnContext(closed).Err(); err != context.Canceled {
+		t.Errorf("Closing the connection should have cancelled its context, got: %v", err)
+	}
+	if err := netutil.ConnContext(a).Err(); err != nil {
+		t.Errorf("The context of an open connection is done: %v", err)
+	}
+
+	cancelParent()
+	for _, c := range []net.Conn{a, b} {
+		select {
+		case <-netutil.ConnContext(c).Done():
+		case <-time.After(1 * time.Second):
+			t.Error("The connection's context has not been cancelled along with the base")
+		}
+	}
+	if ctx := netutil.ConnContext(&netutiltest.Conn{}); ctx != context.Background() {
+		t.Errorf("Any other connection should get the background context, got: %v", ctx)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// debugState is what DebugHandler responds with.
+type debugState struct {
+	ActiveConns    int        `json:"active_conns"`
+	RemoteAddrs    []string   `json:"remote_addrs"`
+	Deadline       *time.Time `json:"deadline,omitempty"` // Absent while busy.
+	Done           bool       `json:"done"`
+	Err            string     `json:"err,omitempty"`
+	EverActive     bool       `json:"ever_active"`
+	IdleSeconds    float64    `json:"idle_seconds"`
+	ActiveSeconds  float64    `json:"active_seconds"`
+	BytesIn        int64      `json:"bytes_in"`
+	BytesOut       int64      `json:"bytes_out"`
+	PreShutdownErr string     `json:"pre_shutdown_err,omitempty"`
+}
+
+// DebugHandler returns a handler that responds with the state of the
+// tracker as JSON, for ops tooling. It is read-only, and meant to be
+// registered under a path like /debug/idletracker.
+//
+// Don't wrap it in Handler, else looking would keep the service alive.
+func (t *IdleTracker) DebugHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "read-only", http.StatusMethodNotAllowed)
+			return
+		}
+
+		s := t.Stats()
+		state := debugState{
+			RemoteAddrs:   []string{},
+			EverActive:    t.EverActive(),
+			IdleSeconds:   s.IdleDuration.Seconds(),
+			ActiveSeconds: s.ActiveDuration.Seconds(),
+			BytesIn:       s.BytesIn,
+			BytesOut:      s.BytesOut,
+		}
+		t.ForEachConn(func(c net.Conn) {
+			state.ActiveConns++
+			if addr := c.RemoteAddr(); addr != nil {
+				state.RemoteAddrs = append(state.RemoteAddrs, addr.String())
+			}
+		})
+		sort.Strings(state.RemoteAddrs)
+		if deadline, ok := t.Deadline(); ok {
+			state.Deadline = &deadline
+		}
+		if err := t.Err(); err != nil {
+			state.Done = true
+			state.Err = err.Error()
+		}
+		if err := t.PreShutdownErr(); err != nil {
+			state.PreShutdownErr = err.Error()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(state)
+	}
+}
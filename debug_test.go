@@ -0,0 +1,76 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestDebugHandler(t *testing.T) {
+	clock := newFakeClock()
+	parentCtx, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+	i := netutil.NewIdleTracker(parentCtx, 1*time.Hour, netutil.WithClock(clock.Now))
+	h := i.DebugHandler()
+	get := func() map[string]interface{} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/idletracker", nil))
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("Invalid JSON: %v\n%s", err, rec.Body.Bytes())
+		}
+		return got
+	}
+
+	got := get()
+	if _, ok := got["deadline"].(string); !ok {
+		t.Errorf("While idle, there should be a deadline, got: %v", got["deadline"])
+	}
+	if got["done"] != false || got["ever_active"] != false || got["active_conns"] != 0.0 {
+		t.Errorf("Unexpected state of a fresh tracker: %v", got)
+	}
+
+	clock.Advance(2 * time.Second)
+	remote, _ := net.ResolveTCPAddr("tcp", "192.0.2.1:4711")
+	i.ConnState(&netutiltest.Conn{Remote: remote}, http.StateActive)
+	clock.Advance(3 * time.Second)
+	got = get()
+	if _, present := got["deadline"]; present {
+		t.Errorf("While busy, there should be no deadline, got: %v", got["deadline"])
+	}
+	if got["active_conns"] != 1.0 || got["ever_active"] != true {
+		t.Errorf("Unexpected state with one connection: %v", got)
+	}
+	if want := []interface{}{"192.0.2.1:4711"}; !reflect.DeepEqual(got["remote_addrs"], want) {
+		t.Errorf("remote_addrs = %v, want %v", got["remote_addrs"], want)
+	}
+	if got["idle_seconds"] != 2.0 || got["active_seconds"] != 3.0 {
+		t.Errorf("idle/active seconds = %v/%v, want 2/3", got["idle_seconds"], got["active_seconds"])
+	}
+
+	cancelParent()
+	<-i.Done()
+	got = get()
+	if got["done"] != true || got["err"] != context.Canceled.Error() {
+		t.Errorf("Unexpected state after the parent has been cancelled: %v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/debug/idletracker", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST should be rejected, got status %d", rec.Code)
+	}
+}
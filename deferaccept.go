@@ -0,0 +1,54 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// DeferAccept returns a control func for ListenControl that sets
+// TCP_DEFER_ACCEPT, for Accept to return connections only once their
+// peer has sent data, or timeout has run out. Connections that never send
+// any, such as by port scanners, then neither wake the process
+// nor count as activity.
+//
+// This is supported on Linux only. Elsewhere the func returns an error.
+// timeout gets rounded up to full seconds.
+func DeferAccept(timeout time.Duration) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = setDeferAccept(fd, deferAcceptSeconds(timeout))
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
+
+// SetDeferAccept sets TCP_DEFER_ACCEPT on a listener that already listens,
+// such as one passed by socket activation, see DeferAccept.
+func SetDeferAccept(ln net.Listener, timeout time.Duration) error {
+	sc, ok := ln.(syscall.Conn)
+	if !ok {
+		return errors.New("netutil: SetDeferAccept needs a listener with a file descriptor")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return DeferAccept(timeout)("", "", rc)
+}
+
+func deferAcceptSeconds(timeout time.Duration) int {
+	secs := int((timeout + time.Second - 1) / time.Second)
+	if secs < 1 {
+		return 1
+	}
+	return secs
+}
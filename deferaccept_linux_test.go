@@ -0,0 +1,68 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func TestDeferAccept(t *testing.T) {
+	ln, err := netutil.ListenControl("tcp", "127.0.0.1:0", netutil.DeferAccept(5*time.Second))
+	if err != nil {
+		t.Fatalf("ListenControl: %v", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer client.Close()
+	select {
+	case c := <-accepted:
+		c.Close()
+		t.Fatal("Accept returned a connection that has not sent anything")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	client.Write([]byte("GET"))
+	select {
+	case c := <-accepted:
+		if c == nil {
+			t.Fatal("Accept failed")
+		}
+		c.Close()
+	case <-time.After(2 * time.Second):
+		t.Fatal("Accept did not return once data has arrived")
+	}
+}
+
+func TestSetDeferAccept(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer ln.Close()
+	if err := netutil.SetDeferAccept(ln, 1500*time.Millisecond); err != nil {
+		t.Fatalf("SetDeferAccept: %v", err)
+	}
+
+	rc, _ := ln.(*net.TCPListener).SyscallConn()
+	var opt int
+	rc.Control(func(fd uintptr) {
+		opt, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_DEFER_ACCEPT)
+	})
+	if err != nil || opt < 2 {
+		t.Errorf("TCP_DEFER_ACCEPT = %d, %v; want at least 2 seconds", opt, err)
+	}
+}
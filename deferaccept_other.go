@@ -0,0 +1,16 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package netutil
+
+import (
+	"errors"
+)
+
+func setDeferAccept(fd uintptr, secs int) error {
+	return errors.New("netutil: TCP_DEFER_ACCEPT is supported on Linux only")
+}
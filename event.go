@@ -0,0 +1,138 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// EventKind tells the lifecycle events of a tracker apart.
+type EventKind int
+
+// Lifecycle events reported to the funcs set by WithEventHook.
+const (
+	EventConnNew         EventKind = iota // A connection is being tracked.
+	EventConnClosed                       // A connection is no longer tracked.
+	EventIdle                             // The last connection is gone, the countdown runs.
+	EventDeadlineReset                    // The deadline has changed while idle.
+	EventDeadlineClamped                  // The hard deadline cuts the patience short.
+	EventFired                            // The tracker is done.
+	EventDrained                          // No connection is left after firing, see Drained.
+)
+
+// String implements the fmt.Stringer interface.
+func (k EventKind) String() string {
+	switch k {
+	case EventConnNew:
+		return "conn-new"
+	case EventConnClosed:
+		return "conn-closed"
+	case EventIdle:
+		return "idle-entered"
+	case EventDeadlineReset:
+		return "deadline-reset"
+	case EventDeadlineClamped:
+		return "deadline-clamped"
+	case EventFired:
+		return "fired"
+	case EventDrained:
+		return "drained"
+	}
+	return "unknown"
+}
+
+// Event describes something that happened to a tracker.
+type Event struct {
+	Kind        EventKind
+	Time        time.Time // As told by the tracker's clock.
+	ActiveConns int       // Tracked connections, after the event.
+
+	RemoteAddr net.Addr  // Of the connection, on EventConnNew and EventConnClosed.
+	Deadline   time.Time // On EventIdle, EventDeadlineReset, and EventDeadlineClamped.
+	Err        error     // On EventFired, what Err returns.
+}
+
+// WithEventHook has the tracker call fn for every lifecycle event,
+// such as for logging. It can be given more than once.
+//
+// fn gets called synchronously with the tracker's lock held,
+// hence must be quick and not call any of the tracker's methods.
+func WithEventHook(fn func(Event)) Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithEventHook"); err != nil {
+			return err
+		}
+		if fn == nil {
+			return errors.New("netutil: WithEventHook needs a non-nil func")
+		}
+		t.eventHooks = append(t.eventHooks, fn)
+		return nil
+	}
+}
+
+// WithEventBuffer has the tracker keep its last n lifecycle events,
+// for RecentEvents to tell what led up to it being done.
+func WithEventBuffer(n int) Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithEventBuffer"); err != nil {
+			return err
+		}
+		if n < 1 {
+			return errors.New("netutil: WithEventBuffer needs a positive size")
+		}
+		t.events = eventRing{buf: make([]Event, 0, n)}
+		return nil
+	}
+}
+
+// RecentEvents returns the last lifecycle events, oldest first,
+// as kept WithEventBuffer. It is nil without that option.
+func (t *IdleTracker) RecentEvents() []Event {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.events.ordered()
+}
+
+// eventRing keeps the latest events, overwriting the oldest once full.
+type eventRing struct {
+	buf  []Event
+	next int // Where the oldest is, once full.
+}
+
+func (r *eventRing) add(ev Event) {
+	if len(r.buf) < cap(r.buf) {
+		r.buf = append(r.buf, ev)
+		return
+	}
+	r.buf[r.next] = ev
+	r.next = (r.next + 1) % len(r.buf)
+}
+
+func (r *eventRing) ordered() []Event {
+	if r.buf == nil {
+		return nil
+	}
+	evs := make([]Event, 0, len(r.buf))
+	evs = append(evs, r.buf[r.next:]...)
+	return append(evs, r.buf[:r.next]...)
+}
+
+// emit completes ev and reports it to any hooks.
+// Must be called with the write lock held.
+func (t *IdleTracker) emit(ev Event) {
+	if len(t.eventHooks) == 0 && t.events.buf == nil {
+		return
+	}
+	ev.Time = t.now()
+	ev.ActiveConns = t.dangling.len()
+	if t.events.buf != nil {
+		t.events.add(ev)
+	}
+	for _, fn := range t.eventHooks {
+		fn(ev)
+	}
+}
@@ -0,0 +1,56 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func TestWithEventBuffer(t *testing.T) {
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithClock(clock.Now),
+		netutil.WithEventBuffer(4))
+	if evs := i.RecentEvents(); len(evs) != 0 {
+		t.Fatalf("Got events before any happened: %v", evs)
+	}
+
+	a, b := newRemoteConn(t, "192.0.2.1:4711"), newRemoteConn(t, "192.0.2.2:4711")
+	for _, step := range []struct {
+		conn  net.Conn
+		state http.ConnState
+	}{{a, http.StateNew}, {b, http.StateNew}, {a, http.StateClosed}, {b, http.StateClosed}} {
+		clock.Advance(1 * time.Second)
+		i.ConnState(step.conn, step.state)
+	}
+	idleSince := clock.Now()
+	clock.Advance(1 * time.Second)
+	i.Fire(nil)
+
+	want := []struct {
+		kind   netutil.EventKind
+		active int
+		remote net.Addr
+		at     time.Time
+	}{
+		{netutil.EventConnClosed, 0, b.RemoteAddr(), idleSince},
+		{netutil.EventIdle, 0, nil, idleSince},
+		{netutil.EventFired, 0, nil, clock.Now()},
+		{netutil.EventDrained, 0, nil, clock.Now()},
+	}
+	evs := i.RecentEvents()
+	if len(evs) != len(want) {
+		t.Fatalf("Got %d events, want the last %d: %v", len(evs), len(want), evs)
+	}
+	for n, w := range want {
+		ev := evs[n]
+		if ev.Kind != w.kind || ev.ActiveConns != w.active || ev.RemoteAddr != w.remote || !ev.Time.Equal(w.at) {
+			t.Errorf("Event %d = %+v, want %v with %d conns from %v at %v", n, ev, w.kind, w.active, w.remote, w.at)
+		}
+	}
+}
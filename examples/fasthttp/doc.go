@@ -0,0 +1,8 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fasthttp tests package netutil with fasthttp's server.
+//
+// It is a module of its own, so that package netutil doesn't depend on fasthttp.
+package fasthttp
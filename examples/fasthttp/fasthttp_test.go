@@ -0,0 +1,95 @@
+// This file is released into the public domain.
+
+package fasthttp_test
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	netutil "github.com/wmark/go.netutil"
+)
+
+func ExampleIdleTracker_WrapListener_fasthttp() {
+	// fasthttp's server has no ConnState hook,
+	// hence its connections get tracked by the listener.
+
+	tracker := netutil.NewIdleTracker(context.Background(), 15*time.Minute)
+	ln, err := net.Listen("tcp", "localhost:8080")
+	if err != nil {
+		log.Fatalf("net.Listen: %v", err)
+	}
+
+	server := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.WriteString("hello")
+		},
+		IdleTimeout: 30 * time.Second, // Else keep-alive connections linger.
+	}
+	go server.Serve(tracker.WrapListener(ln))
+	<-tracker.Done()
+	server.Shutdown()
+}
+
+func TestWrapListenerFasthttp(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 50*time.Millisecond)
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	server := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.WriteString("ok")
+		},
+		IdleTimeout: 100 * time.Millisecond,
+	}
+	go server.Serve(i.WrapListener(ln))
+	defer server.Shutdown()
+
+	const maxConns = 4
+	client := &fasthttp.HostClient{
+		Addr:               ln.Addr().String(),
+		MaxConns:           maxConns,
+		MaxConnWaitTimeout: 1 * time.Second,
+	}
+	var wg sync.WaitGroup
+	for k := 0; k < 8; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				status, body, err := client.GetTimeout(nil, "http://"+client.Addr+"/", 1*time.Second)
+				if err != nil || status != fasthttp.StatusOK || string(body) != "ok" {
+					t.Errorf("GET: %d %q %v", status, body, err)
+					return
+				}
+				if _, idle := i.Deadline(); idle {
+					t.Error("The tracker is idle while connections are open")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := i.Stats().ConnStates[http.StateNew]; n < 1 || n > maxConns {
+		t.Errorf("Counted %d new connections, want at most %d, reused", n, maxConns)
+	}
+
+	// The client keeps its connections, until the server closes them being idle.
+	select {
+	case <-i.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatalf("The tracker has not fired after the last connection, %d still tracked", i.ActiveConns())
+	}
+	if n := i.ActiveConns(); n != 0 {
+		t.Errorf("ActiveConns = %d after all connections have been closed", n)
+	}
+	if s := i.Stats(); s.ConnStates[http.StateNew] != s.ConnStates[http.StateClosed] {
+		t.Errorf("Counted %d new but %d closed connections",
+			s.ConnStates[http.StateNew], s.ConnStates[http.StateClosed])
+	}
+}
@@ -0,0 +1,17 @@
+module github.com/wmark/go.netutil/examples/fasthttp
+
+go 1.25.0
+
+require (
+	github.com/valyala/fasthttp v1.74.0
+	github.com/wmark/go.netutil v0.0.0
+)
+
+require (
+	github.com/klauspost/compress v1.20.0 // indirect
+	github.com/molecule-man/go-brrr v1.0.1 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	golang.org/x/tools v0.48.0 // indirect
+)
+
+replace github.com/wmark/go.netutil => ../..
@@ -0,0 +1,66 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+// ErrFDInUse is returned by AcceptedConnection WithExclusiveFD
+// for a socket that is wrapped already.
+var ErrFDInUse = errors.New("netutil: socket is wrapped already")
+
+// WithExclusiveFD has AcceptedConnection refuse with ErrFDInUse to wrap a
+// socket that another listener, returned by it WithExclusiveFD, still wraps,
+// even if through another file descriptor. This catches handing the same
+// connection to two consumers, which then race for it.
+// The socket is released once that listener has been closed.
+func WithExclusiveFD() AcceptOption {
+	return func(c *acceptedConnection) error {
+		c.exclusive = true
+		return nil
+	}
+}
+
+// exclusiveFDs are the sockets claimed through WithExclusiveFD,
+// as told apart by os.SameFile.
+var exclusiveFDs struct {
+	sync.Mutex
+	claimed []os.FileInfo
+}
+
+// claimFD claims the socket behind f, and returns the token to release it.
+func claimFD(f *os.File) (os.FileInfo, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	exclusiveFDs.Lock()
+	defer exclusiveFDs.Unlock()
+	for _, other := range exclusiveFDs.claimed {
+		if os.SameFile(fi, other) {
+			return nil, ErrFDInUse
+		}
+	}
+	exclusiveFDs.claimed = append(exclusiveFDs.claimed, fi)
+	return fi, nil
+}
+
+// releaseFD undoes claimFD.
+func releaseFD(fi os.FileInfo) {
+	exclusiveFDs.Lock()
+	defer exclusiveFDs.Unlock()
+	for k, other := range exclusiveFDs.claimed {
+		if other == fi {
+			last := len(exclusiveFDs.claimed) - 1
+			exclusiveFDs.claimed[k] = exclusiveFDs.claimed[last]
+			exclusiveFDs.claimed[last] = nil
+			exclusiveFDs.claimed = exclusiveFDs.claimed[:last]
+			return
+		}
+	}
+}
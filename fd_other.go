@@ -0,0 +1,38 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package netutil
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// ErrNotSupported is returned on platforms that cannot pass file descriptors
+// of sockets between processes, such as Windows.
+var ErrNotSupported = errors.New("netutil: file descriptor passing is not supported on this platform")
+
+// describeFD is not implemented where there is no socket activation
+// the way systemd does it, such as on Windows.
+func describeFD(fd int) ActivatedFD {
+	return ActivatedFD{FD: fd, Kind: "other", Err: ErrNotSupported}
+}
+
+// dupFile is not implemented on Windows or Plan 9, for example,
+// where net.FileListener and net.FileConn aren't either.
+func dupFile(f *os.File) (*os.File, error) {
+	return nil, &os.PathError{Op: "dup", Path: f.Name(), Err: ErrNotSupported}
+}
+
+func fileListener(f *os.File) (net.Listener, error) {
+	return nil, &os.PathError{Op: "listen", Path: f.Name(), Err: ErrNotSupported}
+}
+
+func filePacketConn(f *os.File) (net.PacketConn, error) {
+	return nil, &os.PathError{Op: "listen", Path: f.Name(), Err: ErrNotSupported}
+}
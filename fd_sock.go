@@ -0,0 +1,100 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package netutil
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// describeFD tells what kind of file fd is, without taking ownership.
+func describeFD(fd int) ActivatedFD {
+	d := ActivatedFD{FD: fd, Kind: "other"}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		d.Err = os.NewSyscallError("fstat", err)
+		return d
+	}
+	switch st.Mode & syscall.S_IFMT {
+	case syscall.S_IFIFO:
+		d.Kind = "fifo"
+		return d
+	case syscall.S_IFSOCK:
+	default:
+		return d
+	}
+
+	sotype, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE)
+	if err != nil {
+		d.Err = os.NewSyscallError("getsockopt", err)
+		return d
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		d.Err = os.NewSyscallError("getsockname", err)
+		return d
+	}
+	d.Family, d.LocalAddr = sockaddrToAddr(sa, sotype)
+
+	if sotype == syscall.SOCK_DGRAM {
+		d.Kind = "packet"
+		return d
+	}
+	accepting, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_ACCEPTCONN)
+	if err != nil {
+		d.Err = os.NewSyscallError("getsockopt", err)
+		return d
+	}
+	if accepting != 0 {
+		d.Kind = "listener"
+	} else {
+		d.Kind = "conn"
+	}
+	return d
+}
+
+// sockaddrToAddr returns the family and address of a socket of type sotype.
+func sockaddrToAddr(sa syscall.Sockaddr, sotype int) (string, net.Addr) {
+	ip, port, zone, family := net.IP(nil), 0, "", ""
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		ip, port, family = net.IP(sa.Addr[:]), sa.Port, "inet"
+	case *syscall.SockaddrInet6:
+		ip, port, family = net.IP(sa.Addr[:]), sa.Port, "inet6"
+		zone = zoneName(sa.ZoneId)
+	case *syscall.SockaddrUnix:
+		network := "unix"
+		switch sotype {
+		case syscall.SOCK_DGRAM:
+			network = "unixgram"
+		case syscall.SOCK_SEQPACKET:
+			network = "unixpacket"
+		}
+		return "unix", &net.UnixAddr{Name: sa.Name, Net: network}
+	default:
+		return "", nil
+	}
+	if sotype == syscall.SOCK_DGRAM {
+		return family, &net.UDPAddr{IP: ip, Port: port, Zone: zone}
+	}
+	return family, &net.TCPAddr{IP: ip, Port: port, Zone: zone}
+}
+
+// zoneName returns the name of the interface with the given index,
+// or the index itself as does package net if there is none.
+func zoneName(index uint32) string {
+	if index == 0 {
+		return ""
+	}
+	if ifi, err := net.InterfaceByIndex(int(index)); err == nil {
+		return ifi.Name
+	}
+	return strconv.FormatUint(uint64(index), 10)
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package netutil
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// ErrNotSupported is returned on platforms that cannot pass file descriptors
+// of sockets between processes, such as Windows.
+var ErrNotSupported = errors.New("netutil: file descriptor passing is not supported on this platform")
+
+// dupFile returns a duplicate of f that is closed on exec.
+func dupFile(f *os.File) (*os.File, error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var dup int
+	var dupErr error
+	// Unlike f.Fd() this won't switch f to blocking mode.
+	err = rc.Control(func(fd uintptr) {
+		syscall.ForkLock.RLock()
+		dup, dupErr = syscall.Dup(int(fd))
+		if dupErr == nil {
+			syscall.CloseOnExec(dup)
+		}
+		syscall.ForkLock.RUnlock()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if dupErr != nil {
+		return nil, os.NewSyscallError("dup", dupErr)
+	}
+	return os.NewFile(uintptr(dup), f.Name()), nil
+}
+
+func fileListener(f *os.File) (net.Listener, error) {
+	if err := checkSocket(f, "listen"); err != nil {
+		return nil, err
+	}
+	return net.FileListener(f)
+}
+
+func filePacketConn(f *os.File) (net.PacketConn, error) {
+	if err := checkSocket(f, "listen"); err != nil {
+		return nil, err
+	}
+	return net.FilePacketConn(f)
+}
+
+// checkSocket returns ErrNotSocket for anything else, such as FIFOs,
+// for which package net would come up with a less telling error.
+func checkSocket(f *os.File, op string) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return &os.PathError{Op: op, Path: f.Name(), Err: ErrNotSocket}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// FirstByteBounds are the upper bounds of the buckets of Stats.FirstByte.
+var FirstByteBounds = [...]time.Duration{
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	1 * time.Second,
+	10 * time.Second,
+}
+
+// ErrNoFirstByte is the reason passed to the func set by WithCloseObserver
+// for connections closed due to WithFirstByteTimeout.
+var ErrNoFirstByte = errors.New("netutil: no first byte in time")
+
+// FirstByteLatency returns how long after Accept the first byte has been
+// read from c, for connections accepted through WrapListener.
+// The result is false if there has been none yet, or c is another kind.
+func FirstByteLatency(c net.Conn) (time.Duration, bool) {
+	tc, ok := c.(*trackedConn)
+	if !ok {
+		return 0, false
+	}
+	d := atomic.LoadInt64(&tc.firstByte)
+	return time.Duration(d), d > 0
+}
+
+// recordFirstByte notes the time to the first byte, once.
+func (c *trackedConn) recordFirstByte() {
+	d := time.Since(c.acceptedAt)
+	if d <= 0 {
+		d = 1 // Zero is for none yet.
+	}
+	if !atomic.CompareAndSwapInt64(&c.firstByte, 0, int64(d)) {
+		return
+	}
+	k := 0
+	for k < len(FirstByteBounds) && d > FirstByteBounds[k] {
+		k++
+	}
+	atomic.AddInt64(&c.tracker.firstByteCounts[k], 1)
+}
+
+// closeIfSilent closes the connection if nothing has been read yet,
+// see WithFirstByteTimeout.
+func (c *trackedConn) closeIfSilent() {
+	if atomic.LoadInt64(&c.firstByte) > 0 {
+		return
+	}
+	c.mu.Lock()
+	c.lastErr = ErrNoFirstByte
+	c.mu.Unlock()
+	c.Close()
+}
@@ -1,5 +1,3 @@
 module github.com/wmark/go.netutil
 
 go 1.15
-
-require github.com/coreos/go-systemd/v22 v22.1.0
@@ -0,0 +1,29 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.24
+// +build go1.24
+
+package netutil
+
+import (
+	"net/http"
+)
+
+// EnableH2C has server accept HTTP/2 without TLS, "h2c" with prior knowledge,
+// such as for gRPC, alongside HTTP/1. Use this with connections handed over
+// by socket activation, see AcceptedConnection, where a single connection
+// can carry any number of concurrent requests.
+//
+// To ConnState such a connection is StateActive while any of its streams is
+// open, and StateIdle in between, like a keep-alive HTTP/1 connection. Hence
+// the tracker sees the requests, but WithKeepAliveCounts has the connection
+// keep the service alive for as long as it lasts.
+func EnableH2C(server *http.Server) {
+	if server.Protocols == nil {
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+	}
+	server.Protocols.SetUnencryptedHTTP2(true)
+}
@@ -0,0 +1,95 @@
+// This file is released into the public domain.
+
+//go:build go1.24 && !windows
+// +build go1.24,!windows
+
+package netutil_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func TestEnableH2COverActivatedConnection(t *testing.T) {
+	f, client := acceptedFile(t)
+	defer client.Close()
+	ln, err := netutil.AcceptedConnection(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("netutil.AcceptedConnection: %v\n", err)
+	}
+
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	var (
+		mu     sync.Mutex
+		states []http.ConnState
+	)
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+		ConnState: func(c net.Conn, state http.ConnState) {
+			mu.Lock()
+			states = append(states, state)
+			mu.Unlock()
+			i.ConnState(c, state)
+		},
+	}
+	netutil.EnableH2C(server)
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ln) }()
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	var dialed bool
+	httpClient := &http.Client{Transport: &http.Transport{
+		Protocols: protocols,
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			if dialed {
+				t.Error("The transport dialed a second connection")
+			}
+			dialed = true
+			return client, nil
+		},
+	}}
+	for n := 0; n < 3; n++ {
+		resp, err := httpClient.Get("http://activated/")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "HTTP/2.0" {
+			t.Errorf("Got a response over %s, want HTTP/2.0", body)
+		}
+	}
+
+	// Between streams the connection is idle, as is the tracker.
+	if _, ok := i.Deadline(); !ok {
+		t.Error("The tracker should be idle with no stream open")
+	}
+	mu.Lock()
+	want := []http.ConnState{http.StateNew, http.StateActive, http.StateIdle}
+	if len(states) < len(want) || states[0] != want[0] || states[len(states)-2] != want[1] || states[len(states)-1] != want[2] {
+		t.Errorf("ConnState saw %v, want it to begin with %v and end with %v", states, want[0], want[1:])
+	}
+	mu.Unlock()
+
+	client.Close() // Ends the connection, and thereby Serve.
+	select {
+	case err := <-served:
+		if err != os.ErrClosed {
+			t.Errorf("Serve returned %v, want os.ErrClosed", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve has not returned after the connection has been closed")
+	}
+}
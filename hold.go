@@ -0,0 +1,36 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"sync"
+)
+
+// Hold keeps the tracker from idling until release is called, regardless of
+// any connections. Use this for work that is not tied to any connection,
+// such as follow-up work triggered by a webhook.
+//
+// Holds stack: the tracker idles once all have been released and no
+// connections are left. Calling release more than once has no effect.
+func (t *IdleTracker) Hold() (release func()) {
+	t.mu.Lock()
+	if t.busy() == 0 {
+		t.stopIdling()
+	}
+	t.holds++
+	t.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			t.holds--
+			if t.busy() == 0 {
+				t.startIdling()
+			}
+			t.mu.Unlock()
+		})
+	}
+}
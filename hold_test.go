@@ -0,0 +1,41 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestHold(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond)
+
+	outer := i.Hold()
+	inner := i.Hold()
+	c := &netutiltest.Conn{}
+	i.ConnState(c, http.StateActive)
+	i.ConnState(c, http.StateClosed)
+	inner()
+	inner() // No effect.
+
+	select {
+	case <-i.Done():
+		t.Fatal("The tracker fired while held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, ok := i.Deadline(); ok {
+		t.Error("A held tracker should not have a deadline")
+	}
+
+	outer()
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("The tracker has not fired after all holds have been released")
+	}
+}
@@ -8,9 +8,11 @@ package netutil
 
 import (
 	"context"
+	"errors"
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,103 +23,674 @@ var _ context.Context = &IdleTracker{}
 //
 // It can be used in place of a context.WithDeadline to bind any
 // lifetime/runtime of residual work to that of the server's.
+// That includes requests, by having http.Server derive their contexts from it:
+//
+//	server.BaseContext = func(net.Listener) context.Context { return tracker }
 type IdleTracker struct {
-	mu       sync.RWMutex
-	dangling map[net.Conn]struct{}
+	// Through connections accepted by WrapListener, and by http.ConnState.
+	// Accessed atomically, hence first for their alignment.
+	bytesIn, bytesOut int64
+	stateCounts       [http.StateClosed + 1]int64
+	firstByteCounts   [len(FirstByteBounds) + 1]int64
+
+	// In UnixNano, noted by fastConnState, see lastActiveAt and takeClassDeadline.
+	notedActive, notedClassDeadline int64
+
+	mu         sync.RWMutex
+	dangling   *connSet // By connKey.
+	inflight   int      // Requests passing through Handler.
+	holds      int      // See Hold.
+	paused     bool
+	everActive bool
+	concurrent bool // See WithConcurrentBookkeeping. Fixed, hence read without the lock.
+
+	// The latest deadline any of the gone connections asked for, see ConnStateFor.
+	classDeadline time.Time
+
+	keepAliveCounts bool
+	recencyPatience bool // See WithRecencyPatience.
+	awaitingStart   bool // See WithDeferredStart.
+	leakMaxAge      time.Duration
+	leakObserver    func([]net.Conn)
+
+	timer        *timerHandle
+	timerFactory TimerFactory
+	deadline     time.Time
+	patience     time.Duration
+	now          func() time.Time
 
-	timer    *time.Timer
-	deadline time.Time
-	patience time.Duration
+	// Total idle time after which to fire regardless of any activity in between.
+	cumulativeIdle time.Duration
+	warnBefore     time.Duration
+	adaptive       *adaptivePatience
+	spike          *spikeDetector // See WithSpikeShutdown.
+
+	connKey          func(net.Conn) interface{}
+	closeObserver    func(c net.Conn, reason error)
+	eventHooks       []func(Event)
+	events           eventRing // See WithEventBuffer.
+	ignoreLoopback   bool
+	perConnIdle      time.Duration
+	firstByteWait    time.Duration // See WithFirstByteTimeout.
+	livenessInterval time.Duration
+	boundConns       map[*trackedConn]struct{} // See WithConnDeadlines.
+	boundDeadline    time.Time                 // As last set on boundConns.
+
+	// Accounting of idle vs. active periods, see Stats.
+	idle           bool
+	since          time.Time
+	idleDuration   time.Duration
+	activeDuration time.Duration
+	maxIdleGap     time.Duration
 
 	parent  context.Context
-	done    <-chan struct{}
-	permErr error
+	done    chan struct{}
+	drained chan struct{} // See Drained.
+	idled   chan struct{} // See IdleOnlyContext.
+	permErr error         // Guarded by mu.
+
+	firePolicy     FirePolicy
+	hardTimer      *time.Timer // See SetHardDeadline.
+	hardDeadline   time.Time
+	preShutdown    func(context.Context) error
+	preShutdownErr error
+
+	forward *IdleTracker // Set by TransferTo.
+	name    string       // See WithRegister.
+
+	// Of the servers bound by BindServers.
+	shutdowns    sync.WaitGroup
+	shutdownErrs []error
+
+	shutdownBudget time.Duration // See WithShutdownBudget.
+	shutdownSteps  int
+
+	shutdownWriteTimeout time.Duration
+	lastRequestGrace     time.Duration // See WithLastRequestGrace.
+	lastActive           time.Time     // When any connection has last been seen active.
+	graceUntil           time.Time     // Set by fire, see WithLastRequestGrace.
+	running              bool          // Options applied from now on come through Reconfigure.
 }
 
 // NewIdleTracker returns an instance with a running deadline timer.
 // That is, even absent any original connection, the service will have a lifetime.
 //
 // Don't reuse this as its assumption is that a server that has been torn down won't be revived.
-func NewIdleTracker(parent context.Context, patience time.Duration) *IdleTracker {
+//
+// A patience of zero or less gets replaced by 15 minutes.
+// NewIdleTracker panics if any of the options is invalid.
+func NewIdleTracker(parent context.Context, patience time.Duration, opts ...Option) *IdleTracker {
 	if patience <= 0 {
 		patience = 15 * time.Minute
 	}
-	t := time.NewTimer(patience)
-	doneChan := make(chan struct{})
+	i, err := newIdleTracker(parent, patience, opts)
+	if err != nil {
+		panic(err)
+	}
+	return i
+}
+
+// ErrInvalidPatience is returned by NewIdleTrackerErr and WithPatience
+// for a patience of zero or less.
+var ErrInvalidPatience = errors.New("netutil: patience must be positive")
+
+// NewIdleTrackerErr is like NewIdleTracker,
+// but returns an error instead of making up for an invalid patience or option.
+func NewIdleTrackerErr(parent context.Context, patience time.Duration, opts ...Option) (*IdleTracker, error) {
+	if patience <= 0 {
+		return nil, ErrInvalidPatience
+	}
+	return newIdleTracker(parent, patience, opts)
+}
+
+func newIdleTracker(parent context.Context, patience time.Duration, opts []Option) (*IdleTracker, error) {
 	i := &IdleTracker{
-		done:     doneChan,
-		dangling: make(map[net.Conn]struct{}),
+		done:     make(chan struct{}),
+		drained:  make(chan struct{}),
+		idled:    make(chan struct{}),
+		dangling: newConnSet(0),
 		patience: patience,
-		timer:    t,
-		deadline: time.Now().Add(patience),
+		now:      time.Now,
 		parent:   parent,
+		idle:     true,
+
+		timerFactory: newRealTimer,
+	}
+	for _, opt := range opts {
+		if err := opt(i); err != nil {
+			return nil, err
+		}
 	}
+	i.running = true
+	if i.leakMaxAge > 0 {
+		go i.detectLeaks()
+	}
+	i.since = i.now()
+	d := i.clampPatience(i.since, i.nextPatience())
+	i.deadline = i.since.Add(d)
+	t := newTimerHandle(i.timerFactory, d)
+	i.timer = t
+	if i.awaitingStart {
+		t.Stop()
+	}
+	// Last, for its timer could fire right away.
+	i.mu.Lock()
+	i.armHardTimer()
+	i.mu.Unlock()
 
 	parentDone := parent.Done()
 	if parentDone == nil {
 		// Cannot be cancelled, ever, therefore rely on our timer and skip racking up its counter.
 		go func() {
-			<-t.C
-			i.permErr = context.DeadlineExceeded
-			close(doneChan)
+			for {
+				select {
+				case <-t.C:
+					if i.fireIdle() {
+						return
+					}
+				case <-i.done: // Fired by other means.
+					return
+				}
+			}
 		}()
-		return i
+		register(i)
+		return i, nil
 	}
 
 	select {
 	case <-parentDone:
 		// Avoid a goroutine.
-		i.permErr = parent.Err()
-		i.deadline = time.Now()
-		close(doneChan)
-		return i
+		i.mu.Lock()
+		i.deadline = i.now()
+		i.mu.Unlock()
+		i.fire(parent.Err())
+		return i, nil
 	default:
 	}
 
 	go func() {
-		select {
-		case <-parent.Done():
-			i.permErr = parent.Err()
-		case <-t.C:
-			i.permErr = context.DeadlineExceeded
+		for {
+			select {
+			case <-parent.Done():
+				i.fire(parent.Err())
+				return
+			case <-t.C:
+				if i.fireIdle() {
+					return
+				}
+			case <-i.done:
+				return
+			}
 		}
-		close(doneChan)
 	}()
-	return i
+	register(i)
+	return i, nil
+}
+
+// fireIdle is what happens once the patience has run out.
+// It reports whether the tracker is done, which depends on the FirePolicy.
+func (t *IdleTracker) fireIdle() bool {
+	t.mu.RLock()
+	fn, fired := t.preShutdown, t.permErr != nil
+	t.mu.RUnlock()
+	if fired {
+		// Such as by Fire, in a race with the timer.
+		return true
+	}
+	if fn != nil {
+		ctx, cancel := context.WithTimeout(t.parent, preShutdownTimeout)
+		finished := make(chan struct{})
+		go func() {
+			err := fn(ctx)
+			cancel()
+			t.mu.Lock()
+			t.preShutdownErr = err
+			t.mu.Unlock()
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-t.parent.Done():
+			// The parent wins over any idling, even while fn still runs.
+			t.fire(t.parent.Err())
+			return true
+		}
+	}
+
+	// Connections can have arrived after the timer fired,
+	// or while the func set by SetPreShutdown ran.
+	t.mu.Lock()
+	if !t.hardDeadline.IsZero() && !t.deadline.Before(t.hardDeadline) {
+		// Cut short by the hard deadline, whose timer can be late.
+		t.mu.Unlock()
+		t.fireIdling(ErrHardDeadline)
+		return true
+	}
+	var lingering []net.Conn
+	if t.busy() > 0 {
+		switch t.firePolicy {
+		case FireWait:
+			// Any last connection re-arms the timer.
+			t.mu.Unlock()
+			return false
+		case FireClose:
+			lingering = t.dangling.conns()
+		}
+	}
+	t.mu.Unlock()
+
+	t.fireIdling(context.DeadlineExceeded)
+	for _, c := range lingering {
+		c.Close()
+	}
+	return true
+}
+
+// fire sets the error before closing the done channel,
+// so that anyone observing the latter will find the former.
+func (t *IdleTracker) fire(err error) {
+	t.fireWith(err, false)
+}
+
+// fireIdling is fire on account of idling or the hard deadline,
+// which ends IdleOnlyContext as well.
+func (t *IdleTracker) fireIdling(err error) {
+	t.fireWith(err, true)
+}
+
+func (t *IdleTracker) fireWith(err error, idling bool) {
+	t.mu.Lock()
+	if t.permErr != nil {
+		t.mu.Unlock()
+		return
+	}
+	t.permErr = err
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	if t.hardTimer != nil {
+		t.hardTimer.Stop()
+	}
+	if t.idle {
+		if gap := t.now().Sub(t.since); gap > t.maxIdleGap {
+			t.maxIdleGap = gap
+		}
+	}
+	if t.lastRequestGrace > 0 {
+		now, last := t.now(), t.lastActiveAt()
+		if t.inflight > 0 {
+			last = now
+		}
+		t.dangling.each(func(_ interface{}, ci *connInfo) bool {
+			if ci.state == http.StateActive {
+				last = now
+				return false
+			}
+			return true
+		})
+		if !last.IsZero() && now.Sub(last) < t.lastRequestGrace {
+			t.graceUntil = last.Add(t.lastRequestGrace)
+		}
+	}
+	t.emit(Event{Kind: EventFired, Err: err})
+	drained := t.dangling.len() == 0
+	if drained {
+		// Reported ahead of Done, as is EventFired.
+		t.emit(Event{Kind: EventDrained})
+	}
+	close(t.done)
+	if idling {
+		close(t.idled)
+	}
+	if drained {
+		close(t.drained)
+	}
+	d := t.shutdownWriteTimeout
+	var conns []net.Conn
+	if d > 0 {
+		conns = t.dangling.conns()
+	}
+	t.mu.Unlock()
+	if t.name != "" {
+		unregister(t)
+	}
+
+	deadline := time.Now().Add(d)
+	for _, c := range conns {
+		c.SetWriteDeadline(deadline)
+	}
 }
 
 // ConnState implements the net/http.Server.ConnState interface.
 func (t *IdleTracker) ConnState(conn net.Conn, state http.ConnState) {
+	t.connState(conn, state, 0)
+}
+
+// ConnStateFor returns a ConnState for a class of connections,
+// such as those of one listener, that leave the tracker
+// waiting for the given patience once the last of them is gone.
+//
+// Should several classes have had connections, the latest resulting
+// deadline wins. Connections seen through ConnState use the
+// tracker's own patience.
+func (t *IdleTracker) ConnStateFor(patience time.Duration) func(net.Conn, http.ConnState) {
+	if patience <= 0 {
+		return t.ConnState
+	}
+	return func(conn net.Conn, state http.ConnState) {
+		t.connState(conn, state, patience)
+	}
+}
+
+// connState is ConnState with a class patience, zero being the tracker's.
+func (t *IdleTracker) connState(conn net.Conn, state http.ConnState, patience time.Duration) {
+	t.mu.RLock()
+	fwd := t.forward
+	t.mu.RUnlock()
+	if fwd != nil {
+		fwd.connState(conn, state, patience)
+		return
+	}
+
+	if t.ignoreLoopback {
+		if ip := remoteIP(conn); ip != nil && ip.IsLoopback() {
+			return
+		}
+	}
+
+	if state >= 0 && int(state) < len(t.stateCounts) {
+		atomic.AddInt64(&t.stateCounts[state], 1)
+	}
+	key := t.keyOf(conn)
+	if t.concurrent && patience == 0 && t.fastConnState(key, conn, state) {
+		return
+	}
+
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	if t.awaitingStart {
+		defer t.startLocked()
+	}
 
-	oldActive := len(t.dangling)
+	oldActive := t.busy()
 	switch state {
 	case http.StateNew, http.StateActive:
-		t.dangling[conn] = struct{}{}
+		// Any transition can get reported more than once, such as by
+		// middleware, which must neither count twice nor reset anything.
+		ci, tracked := t.dangling.get(key)
+		if state == http.StateNew && t.adaptive != nil && !tracked {
+			t.adaptive.observe(t.now())
+		}
+		if state == http.StateNew && t.spike != nil && !tracked &&
+			t.permErr == nil && t.spike.observe(t.now()) {
+			go t.fire(ErrAcceptSpike) // mu is held.
+		}
+		if state == http.StateActive {
+			t.everActive = true
+		}
+		now := t.now()
+		if tracked {
+			t.noteActive(ci, now)
+			ci.conn, ci.state = conn, state
+		} else {
+			ci = &connInfo{conn: conn, state: state, since: now}
+			t.dangling.put(key, ci)
+			t.emit(Event{Kind: EventConnNew, RemoteAddr: conn.RemoteAddr()})
+		}
+		if patience > 0 {
+			ci.patience = patience
+		}
 		if oldActive == 0 {
-			t.timer.Stop()
+			t.stopIdling()
 		}
 	case http.StateHijacked:
-		delete(t.dangling, conn)
+		if ci, tracked := t.dangling.get(key); tracked {
+			t.dangling.del(key)
+			t.emit(Event{Kind: EventConnClosed, RemoteAddr: ci.conn.RemoteAddr()})
+			t.checkDrained()
+		}
 	case http.StateIdle, http.StateClosed:
-		delete(t.dangling, conn)
-		if oldActive > 0 && len(t.dangling) == 0 {
-			t.timer.Stop()
-			t.timer.Reset(t.patience)
-			t.deadline = time.Now().Add(t.patience)
+		if state == http.StateIdle && t.keepAliveCounts {
+			if ci, tracked := t.dangling.get(key); tracked {
+				t.noteActive(ci, t.now())
+				ci.state = state
+			}
+			return
+		}
+		t.untrack(key)
+		if oldActive > 0 && t.busy() == 0 {
+			t.startIdling()
+		}
+	}
+}
+
+// EverActive tells whether the tracker has seen any request being served,
+// that is a connection in http.StateActive or a request through Handler.
+//
+// A tracker done without ever having been active indicates a service
+// that has been started for nothing, such as due to over-provisioning.
+func (t *IdleTracker) EverActive() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.everActive
+}
+
+// Evict closes the connection and stops tracking it, as if it had reached
+// http.StateClosed, re-arming the timer if it was the last one.
+// Use this to get rid of a connection that keeps the service from idling.
+//
+// Connections not tracked are left alone.
+func (t *IdleTracker) Evict(c net.Conn) error {
+	key := t.keyOf(c)
+	t.mu.Lock()
+	if _, found := t.dangling.get(key); !found {
+		t.mu.Unlock()
+		return nil
+	}
+	t.untrack(key)
+	if t.busy() == 0 {
+		t.startIdling()
+	}
+	t.mu.Unlock()
+	return c.Close()
+}
+
+// untrack forgets the connection, noting the deadline its class asks for.
+// Must be called with the write lock held.
+func (t *IdleTracker) untrack(key interface{}) {
+	ci, found := t.dangling.get(key)
+	if !found {
+		return
+	}
+	t.dangling.del(key)
+	t.noteActive(ci, t.now())
+	d := ci.patience
+	if d == 0 {
+		d = t.nextPatience()
+	}
+	if deadline := t.countdownStart(t.now()).Add(d); deadline.After(t.classDeadline) {
+		t.classDeadline = deadline
+	}
+	t.emit(Event{Kind: EventConnClosed, RemoteAddr: ci.conn.RemoteAddr()})
+	t.checkDrained()
+}
+
+// checkDrained closes drained once no connection is left after firing.
+// Must be called with the write lock held.
+func (t *IdleTracker) checkDrained() {
+	if t.permErr == nil || t.dangling.len() > 0 {
+		return
+	}
+	select {
+	case <-t.drained:
+	default:
+		close(t.drained)
+		t.emit(Event{Kind: EventDrained})
+	}
+}
+
+// connInfo is what is known about a tracked connection.
+type connInfo struct {
+	conn       net.Conn
+	state      http.ConnState
+	since      time.Time     // When it got tracked.
+	lastActive time.Time     // When it has last been seen in http.StateActive.
+	patience   time.Duration // Of its class, see ConnStateFor, or zero.
+}
+
+// noteActive records that the connection has been active until now,
+// if it has been, before it transitions to another state.
+// Must be called with the write lock held.
+func (t *IdleTracker) noteActive(ci *connInfo, now time.Time) {
+	if ci.state != http.StateActive {
+		return
+	}
+	ci.lastActive = now
+	if now.After(t.lastActive) {
+		t.lastActive = now
+	}
+}
+
+// keyOf returns what identifies the connection, see WithConnKey.
+func (t *IdleTracker) keyOf(c net.Conn) interface{} {
+	if t.connKey == nil {
+		return c
+	}
+	return t.connKey(c)
+}
+
+// busy is the number of anything that keeps the service from idling.
+func (t *IdleTracker) busy() int {
+	n := t.dangling.len() + t.inflight + t.holds
+	if t.paused {
+		n++
+	}
+	return n
+}
+
+// EffectivePatience returns the patience the next idle period would start
+// with as of now, after any scaling by WithAdaptivePatience and capping by
+// WithCumulativeIdle. Patience of classes, see ConnStateFor, is not included.
+func (t *IdleTracker) EffectivePatience() time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.nextPatience()
+}
+
+// nextPatience is how long to wait from the start of an idle period on.
+func (t *IdleTracker) nextPatience() time.Duration {
+	d := t.patience
+	if t.adaptive != nil {
+		d = t.adaptive.patience(t.now())
+	}
+	if t.cumulativeIdle > 0 {
+		remaining := t.cumulativeIdle - t.idleDuration
+		if remaining < 0 {
+			remaining = 0
+		}
+		if remaining < d {
+			d = remaining
 		}
 	}
+	return d
+}
+
+// clampPatience shortens d so that, counting from now, it ends at the latest
+// by the hard deadline. Must be called with the write lock held.
+func (t *IdleTracker) clampPatience(now time.Time, d time.Duration) time.Duration {
+	if t.hardDeadline.IsZero() || !now.Add(d).After(t.hardDeadline) {
+		return d
+	}
+	t.emit(Event{Kind: EventDeadlineClamped, Deadline: t.hardDeadline})
+	return t.hardDeadline.Sub(now)
+}
+
+// countdownStart is when the patience starts running for idling that
+// began at idleSince, which is then unless WithRecencyPatience is in effect.
+// Must be called with the lock held.
+func (t *IdleTracker) countdownStart(idleSince time.Time) time.Time {
+	if !t.recencyPatience {
+		return idleSince
+	}
+	if last := t.lastActiveAt(); !last.IsZero() && last.Before(idleSince) {
+		return last
+	}
+	return idleSince
+}
+
+// stopIdling halts the countdown on the first connection.
+// Must be called with the write lock held.
+func (t *IdleTracker) stopIdling() {
+	t.timer.Stop()
+	if !t.idle {
+		return
+	}
+	t.endIdleGap(t.now())
+	t.idle = false
+	t.syncConnDeadlines()
+}
+
+// endIdleGap accounts for the idle period that ends now.
+// Must be called with the write lock held.
+func (t *IdleTracker) endIdleGap(now time.Time) {
+	gap := now.Sub(t.since)
+	t.idleDuration += gap
+	if gap > t.maxIdleGap {
+		t.maxIdleGap = gap
+	}
+	t.since = now
+}
+
+// startIdling re-arms the countdown after the last connection is gone.
+// Must be called with the write lock held.
+func (t *IdleTracker) startIdling() {
+	if t.permErr != nil || t.forward != nil {
+		return
+	}
+	t.awaitingStart = false // Any activity that has ended counts as start.
+	now := t.now()
+	t.timer.Stop()
+	start := t.countdownStart(now)
+	d := t.nextPatience() - now.Sub(start)
+	if classDeadline := t.takeClassDeadline(); !classDeadline.IsZero() {
+		d = classDeadline.Sub(now)
+	}
+	d = t.clampPatience(now, d)
+	t.timer.Reset(d)
+	t.deadline = now.Add(d)
+	if t.idle {
+		t.emit(Event{Kind: EventDeadlineReset, Deadline: t.deadline})
+	} else {
+		t.emit(Event{Kind: EventIdle, Deadline: t.deadline})
+	}
+	defer t.syncConnDeadlines()
+	if t.idle {
+		return
+	}
+	t.activeDuration += now.Sub(t.since)
+	t.since = now
+	t.idle = true
 }
 
 // Deadline implements the context.Context interface
 // but breaks the promise of always returning the same deadline.
+//
+// Should the parent have a deadline that comes first, that is returned,
+// as the tracker won't outlive its parent. Else there is none while busy.
 func (t *IdleTracker) Deadline() (deadline time.Time, ok bool) {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
+	return t.deadlineLocked()
+}
 
-	if len(t.dangling) > 0 {
-		return // ok will be false as we're not idle waiting.
+// deadlineLocked is Deadline. Must be called with the lock held.
+func (t *IdleTracker) deadlineLocked() (deadline time.Time, ok bool) {
+	parentDeadline, parentOK := t.parent.Deadline()
+	if t.busy() > 0 || t.awaitingStart {
+		return parentDeadline, parentOK // We're not idle waiting.
+	}
+	if parentOK && parentDeadline.Before(t.deadline) {
+		return parentDeadline, true
 	}
 	return t.deadline, true
 }
@@ -127,13 +700,27 @@ func (t *IdleTracker) Done() <-chan struct{} {
 	return t.done
 }
 
+// Drained returns a channel that gets closed once no tracked connection is
+// left after the tracker is done, which is right away if there was none.
+// Unlike Done, this tells when it is safe to exit or exec, with any
+// connections having been closed.
+func (t *IdleTracker) Drained() <-chan struct{} {
+	return t.drained
+}
+
 // Err implements the context.Context interface.
 func (t *IdleTracker) Err() error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	return t.permErr
 }
 
 // Value implements the context.Context interface.
+// Under IdleTrackerKey it returns the tracker itself.
 func (t *IdleTracker) Value(key interface{}) interface{} {
+	if key == IdleTrackerKey {
+		return t
+	}
 	return t.parent.Value(key)
 }
 
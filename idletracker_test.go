@@ -9,10 +9,12 @@ import (
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
 )
 
 func ExampleIdleTracker() {
@@ -96,6 +98,35 @@ func TestDeadParent(t *testing.T) {
 	}
 }
 
+func TestTinyPatienceConsistency(t *testing.T) {
+	// Err and Done must agree at any time, starting right after construction.
+	consistent := func(i *netutil.IdleTracker) bool {
+		err := i.Err()
+		closed := isClosed(i.Done())
+		return (err == nil || closed) && (!closed || i.Err() != nil)
+	}
+
+	for n := 0; n < 2000; n++ {
+		parentCtx, cancelParent := context.WithCancel(context.Background())
+		var parent context.Context = parentCtx
+		if n%2 == 0 {
+			parent = context.Background()
+		}
+		i := netutil.NewIdleTracker(parent, time.Duration(1+n%1000)*time.Nanosecond)
+		if n%3 == 0 {
+			cancelParent()
+		}
+		if !consistent(i) {
+			t.Fatalf("Err and Done disagree right after NewIdleTracker, patience: %dns", 1+n%1000)
+		}
+		<-i.Done()
+		if i.Err() == nil {
+			t.Fatal("Done has been closed without Err being set")
+		}
+		cancelParent()
+	}
+}
+
 func TestEmptyCtxParent(t *testing.T) {
 	// Rules out any errors due to a 'nil' returned somewhere.
 	emptyCtx := context.Background()
@@ -237,3 +268,655 @@ func TestConnectionTracking(t *testing.T) {
 		teardownCancel()
 	}
 }
+
+func newRemoteConn(t *testing.T, remote string) net.Conn {
+	addr, err := net.ResolveTCPAddr("tcp", remote)
+	if err != nil {
+		t.Fatalf("net.ResolveTCPAddr(%q): %v", remote, err)
+	}
+	return &netutiltest.Conn{Remote: addr}
+}
+
+func TestIgnoreLoopback(t *testing.T) {
+	timer := &manualTimer{}
+	i := netutil.NewIdleTracker(context.Background(), 50*time.Millisecond,
+		netutil.WithIgnoreLoopback(), netutil.WithTimerFactory(timer.New))
+	agent4 := newRemoteConn(t, "127.0.0.1:4711")
+	agent6 := newRemoteConn(t, "[::1]:4711")
+	client := newRemoteConn(t, "192.0.2.1:4711")
+
+	i.ConnState(agent4, http.StateNew)
+	i.ConnState(agent6, http.StateActive)
+	if _, onDeadline := i.Deadline(); !onDeadline {
+		t.Error("Connections over loopback must not count as activity")
+	}
+	timer.mu.Lock()
+	armed, stops := len(timer.armed), timer.stops
+	timer.mu.Unlock()
+	i.ConnState(client, http.StateNew)
+	if _, onDeadline := i.Deadline(); onDeadline {
+		t.Error("With a connection from elsewhere, IdleTracker should not be on a deadline")
+	}
+	timer.mu.Lock()
+	if len(timer.armed) != armed || timer.stops == stops {
+		t.Error("IdleTracker has left its timer running, although a non-loopback connection is active")
+	}
+	timer.mu.Unlock()
+
+	i.ConnState(client, http.StateClosed)
+	timer.mu.Lock()
+	if len(timer.armed) != armed+1 {
+		t.Error("IdleTracker has not re-armed its timer, although only loopback connections are left")
+	}
+	timer.mu.Unlock()
+	timer.c <- time.Now()
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Error("IdleTracker is not done, although only loopback connections are left")
+	}
+}
+
+func TestIgnoreLoopbackZones(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+		netutil.WithIgnoreLoopback())
+	agent := &netutiltest.Conn{Remote: stringAddr("[::1%lo]:4711")}
+	neighbour := &netutiltest.Conn{Remote: stringAddr("[fe80::1%eth0]:4711")}
+
+	i.ConnState(agent, http.StateNew)
+	if _, onDeadline := i.Deadline(); !onDeadline {
+		t.Error("A loopback address with a zone must not count as activity")
+	}
+	i.ConnState(neighbour, http.StateNew)
+	if _, onDeadline := i.Deadline(); onDeadline {
+		t.Error("A link-local peer should count as activity")
+	}
+}
+
+func TestNewIdleTrackerErr(t *testing.T) {
+	for _, patience := range []time.Duration{0, -1 * time.Second} {
+		i, err := netutil.NewIdleTrackerErr(context.Background(), patience)
+		if err != netutil.ErrInvalidPatience {
+			t.Errorf("NewIdleTrackerErr(%v) should have returned ErrInvalidPatience, got: %v", patience, err)
+		}
+		if i != nil {
+			t.Errorf("NewIdleTrackerErr(%v) returned an instance alongside the error", patience)
+		}
+	}
+
+	if _, err := netutil.NewIdleTrackerErr(context.Background(), 1*time.Second,
+		netutil.WithClock(nil)); err == nil {
+		t.Error("NewIdleTrackerErr should have returned the error of an invalid option")
+	}
+
+	before := time.Now()
+	i, err := netutil.NewIdleTrackerErr(context.Background(), 3*time.Hour)
+	if err != nil {
+		t.Fatalf("NewIdleTrackerErr returned an error for a valid patience: %v", err)
+	}
+	d, _ := i.Deadline()
+	if d.Before(before.Add(3*time.Hour)) || d.After(time.Now().Add(3*time.Hour)) {
+		t.Errorf("The patience has not been used as given, deadline: %v", d)
+	}
+}
+
+func TestBaseContext(t *testing.T) {
+	parentCtx, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+	rootCtx := context.WithValue(parentCtx, "key", "foo")
+	i := netutil.NewIdleTracker(rootCtx, 50*time.Millisecond)
+
+	type observation struct {
+		value   interface{}
+		done    bool
+		errored bool
+	}
+	observed := make(chan observation, 8)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		o := observation{value: ctx.Value("key")}
+		select {
+		case <-ctx.Done():
+			o.done = true
+			o.errored = ctx.Err() != nil
+		case <-time.After(2 * time.Second):
+		}
+		observed <- o
+	})
+	// Deliberately without ConnState, else the pending requests would postpone the deadline.
+	server := &http.Server{
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return i },
+	}
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	go server.Serve(ln)
+	defer server.Close()
+
+	const requests = 4
+	for n := 0; n < requests; n++ {
+		go func() {
+			res, err := http.Get("http://" + ln.Addr().String() + "/")
+			if err == nil {
+				res.Body.Close()
+			}
+		}()
+	}
+	for n := 0; n < requests; n++ {
+		o := <-observed
+		if o.value != "foo" {
+			t.Errorf("The request's context doesn't relay values, got: %v", o.value)
+		}
+		if !o.done || !o.errored {
+			t.Errorf("The request's context has not been done after the tracker has fired: %+v", o)
+		}
+	}
+}
+
+func TestCumulativeIdle(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 200*time.Millisecond,
+		netutil.WithCumulativeIdle(150*time.Millisecond))
+	conn := &netutiltest.Conn{}
+
+	// Intermittent traffic, with gaps well below the patience.
+	var lastReset time.Time
+	for n := 0; n < 3; n++ {
+		<-time.After(60 * time.Millisecond)
+		if isClosed(i.Done()) {
+			break
+		}
+		i.ConnState(conn, http.StateActive)
+		i.ConnState(conn, http.StateIdle)
+		lastReset = time.Now()
+	}
+
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("IdleTracker did not fire, although the idle periods add up to more than its total")
+	}
+	if took := time.Since(lastReset); took > 150*time.Millisecond {
+		t.Errorf("IdleTracker fired %v after the last activity, which is no sooner than without the total", took)
+	}
+	if err := i.Err(); err != context.DeadlineExceeded {
+		t.Errorf("Err = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+// closeCountingConn counts calls to Close.
+type closeCountingConn struct {
+	netutiltest.Conn
+	closed int
+}
+
+func (c *closeCountingConn) Close() error {
+	c.closed++
+	return nil
+}
+
+func TestEvict(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 50*time.Millisecond)
+	a, b := &closeCountingConn{}, &closeCountingConn{}
+	i.ConnState(a, http.StateActive)
+	i.ConnState(b, http.StateActive)
+
+	stranger := &closeCountingConn{}
+	if err := i.Evict(stranger); err != nil || stranger.closed != 0 {
+		t.Errorf("Evicting an unknown connection should be a no-op, got: %v and %d closes", err, stranger.closed)
+	}
+
+	i.Evict(a)
+	if a.closed != 1 {
+		t.Errorf("An evicted connection should have been closed once, was: %d", a.closed)
+	}
+	if _, onDeadline := i.Deadline(); onDeadline {
+		t.Error("With one connection left, IdleTracker should not be on a deadline")
+	}
+
+	before := time.Now()
+	i.Evict(b)
+	d, onDeadline := i.Deadline()
+	if !onDeadline || d.Before(before) {
+		t.Errorf("After evicting the last connection, the timer should have been re-armed, deadline: %v, %v", d, onDeadline)
+	}
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Error("IdleTracker is not done after evicting the last connection")
+	}
+}
+
+// wrappedConn is a stand-in for tls.Conn and the like.
+type wrappedConn struct {
+	net.Conn
+}
+
+func TestConnKey(t *testing.T) {
+	local, _ := net.ResolveTCPAddr("tcp", "192.0.2.2:443")
+	remote, _ := net.ResolveTCPAddr("tcp", "192.0.2.1:4711")
+	underlying := &netutiltest.Conn{Local: local, Remote: remote}
+
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	i.ConnState(&wrappedConn{underlying}, http.StateNew)
+	i.ConnState(&wrappedConn{underlying}, http.StateClosed)
+	if _, onDeadline := i.Deadline(); onDeadline {
+		t.Fatal("Without WithConnKey, differently wrapped connections should be told apart")
+	}
+
+	i = netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+		netutil.WithConnKey(netutil.ConnAddrKey))
+	i.ConnState(&wrappedConn{underlying}, http.StateNew)
+	i.ConnState(underlying, http.StateActive)
+	i.ConnState(&wrappedConn{underlying}, http.StateClosed)
+	if _, onDeadline := i.Deadline(); !onDeadline {
+		t.Error("With ConnAddrKey, the connection should've been recognized as closed")
+	}
+
+	other := &netutiltest.Conn{Local: local, Remote: local}
+	i.ConnState(underlying, http.StateNew)
+	i.ConnState(other, http.StateNew)
+	i.ConnState(underlying, http.StateClosed)
+	if _, onDeadline := i.Deadline(); onDeadline {
+		t.Error("With ConnAddrKey, connections with different addresses should be told apart")
+	}
+}
+
+func TestAdaptivePatience(t *testing.T) {
+	clock := newFakeClock()
+	const min, max = 1 * time.Minute, 11 * time.Minute
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+		netutil.WithClock(clock.Now), netutil.WithAdaptivePatience(min, max))
+	patience := func() time.Duration {
+		d, _ := i.Deadline()
+		return d.Sub(clock.Now())
+	}
+	if got := patience(); got != min {
+		t.Errorf("Without any connections, patience = %v, want %v", got, min)
+	}
+
+	// A burst of 9 connections.
+	netutiltest.DriveConnState(i, []http.ConnState{
+		http.StateNew, http.StateNew, http.StateNew,
+		http.StateNew, http.StateNew, http.StateNew,
+		http.StateNew, http.StateNew, http.StateNew,
+		http.StateClosed, http.StateClosed, http.StateClosed,
+		http.StateClosed, http.StateClosed, http.StateClosed,
+		http.StateClosed, http.StateClosed, http.StateClosed,
+	})
+	busy := patience()
+	if want := min + (max-min)*9/10; busy < want-time.Second || busy > want+time.Second {
+		t.Errorf("After a burst, patience = %v, want about %v", busy, want)
+	}
+
+	// Long after, a single connection.
+	clock.Advance(10 * max)
+	netutiltest.DriveConnState(i, []http.ConnState{http.StateNew, http.StateClosed})
+	quiet := patience()
+	if want := min + (max-min)/2; quiet < want || quiet > want+2*time.Second {
+		t.Errorf("After a quiet period, patience = %v, want about %v", quiet, want)
+	}
+}
+
+func TestEverActive(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond)
+	// A connection that never sends a request, like a port scanner's.
+	netutiltest.DriveConnState(i, []http.ConnState{http.StateNew, http.StateClosed})
+
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("IdleTracker is not done")
+	}
+	if i.EverActive() {
+		t.Error("EverActive is true, but no request has ever been served")
+	}
+
+	i = netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	netutiltest.DriveConnState(i, []http.ConnState{http.StateNew, http.StateActive, http.StateIdle})
+	if !i.EverActive() {
+		t.Error("EverActive is false, but a connection has been active")
+	}
+}
+
+func TestConnStateFor(t *testing.T) {
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithClock(clock.Now))
+	public := i.ConnStateFor(1 * time.Minute)
+	admin := i.ConnStateFor(10 * time.Minute)
+
+	// Only the public class had connections.
+	p := &netutiltest.Conn{}
+	public(p, http.StateNew)
+	public(p, http.StateClosed)
+	if d, _ := i.Deadline(); !d.Equal(clock.Now().Add(1 * time.Minute)) {
+		t.Errorf("The public class's patience has not been used, deadline: %v", d)
+	}
+
+	// The admin connection is gone first, yet its deadline lies further out.
+	a, p := &netutiltest.Conn{}, &netutiltest.Conn{}
+	admin(a, http.StateNew)
+	public(p, http.StateNew)
+	start := clock.Now()
+	admin(a, http.StateClosed)
+	clock.Advance(2 * time.Minute)
+	public(p, http.StateClosed)
+	if d, _ := i.Deadline(); !d.Equal(start.Add(10 * time.Minute)) {
+		t.Errorf("Expected the latest deadline across classes, %v, got: %v", start.Add(10*time.Minute), d)
+	}
+
+	// Unclassified connections fall back to the tracker's patience.
+	c := &netutiltest.Conn{}
+	i.ConnState(c, http.StateNew)
+	i.ConnState(c, http.StateClosed)
+	if d, _ := i.Deadline(); !d.Equal(clock.Now().Add(1 * time.Hour)) {
+		t.Errorf("The tracker's patience has not been used, deadline: %v", d)
+	}
+}
+
+func TestDuplicateStateNew(t *testing.T) {
+	clock := newFakeClock()
+	newTracker := func() *netutil.IdleTracker {
+		return netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+			netutil.WithClock(clock.Now), netutil.WithAdaptivePatience(1*time.Minute, 1*time.Hour))
+	}
+	once, twice := newTracker(), newTracker()
+
+	c := &netutiltest.Conn{}
+	once.ConnState(c, http.StateNew)
+	twice.ConnState(c, http.StateNew)
+	twice.ConnState(c, http.StateNew)
+	if n := twice.ActiveConns(); n != 1 {
+		t.Errorf("A connection reported as new twice should count once, got: %d", n)
+	}
+	if _, ok := twice.Deadline(); ok {
+		t.Error("The timer should be stopped while the connection is active")
+	}
+
+	twice.ConnState(c, http.StateActive)
+	twice.ConnState(c, http.StateNew)
+	twice.ConnState(c, http.StateClosed)
+	twice.ConnState(c, http.StateClosed)
+	once.ConnState(c, http.StateClosed)
+	if n := twice.ActiveConns(); n != 0 {
+		t.Errorf("No connection should be left, got: %d", n)
+	}
+	want, _ := once.Deadline()
+	if got, ok := twice.Deadline(); !ok || !got.Equal(want) {
+		t.Errorf("Duplicates should not have changed the deadline, got: %v, want: %v", got, want)
+	}
+}
+
+func TestEffectivePatience(t *testing.T) {
+	clock := newFakeClock()
+	const min, max = 1 * time.Minute, 11 * time.Minute
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+		netutil.WithClock(clock.Now), netutil.WithAdaptivePatience(min, max))
+	if got := i.EffectivePatience(); got != min {
+		t.Errorf("Without any connections, EffectivePatience = %v, want %v", got, min)
+	}
+	netutiltest.DriveConnState(i, []http.ConnState{
+		http.StateNew, http.StateNew, http.StateNew,
+		http.StateClosed, http.StateClosed, http.StateClosed,
+	})
+	if got, want := i.EffectivePatience(), min+(max-min)*3/4; got != want {
+		t.Errorf("After three connections, EffectivePatience = %v, want %v", got, want)
+	}
+	clock.Advance(100 * max)
+	if got := i.EffectivePatience(); got != min {
+		t.Errorf("Long after, EffectivePatience = %v, want %v", got, min)
+	}
+
+	j := netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+		netutil.WithClock(clock.Now), netutil.WithCumulativeIdle(90*time.Minute))
+	if got := j.EffectivePatience(); got != 1*time.Hour {
+		t.Errorf("EffectivePatience = %v, want the configured 1h", got)
+	}
+	clock.Advance(45 * time.Minute)
+	j.ConnState(&netutiltest.Conn{}, http.StateNew) // Ends the first idle period.
+	if got := j.EffectivePatience(); got != 45*time.Minute {
+		t.Errorf("EffectivePatience = %v, should be capped by the cumulative idle time left, 45m", got)
+	}
+}
+
+func TestParentDeadlineComesFirst(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	parentDeadline, _ := parent.Deadline()
+	i := netutil.NewIdleTracker(parent, 1*time.Hour)
+
+	if d, ok := i.Deadline(); !ok || !d.Equal(parentDeadline) {
+		t.Errorf("Deadline = %v, %v; want the parent's %v", d, ok, parentDeadline)
+	}
+	c := &netutiltest.Conn{}
+	i.ConnState(c, http.StateActive)
+	if d, ok := i.Deadline(); !ok || !d.Equal(parentDeadline) {
+		t.Errorf("Deadline while busy = %v, %v; want the parent's %v", d, ok, parentDeadline)
+	}
+
+	select {
+	case <-i.Done():
+		if time.Now().Before(parentDeadline) {
+			t.Error("The tracker fired before its parent's deadline")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The tracker has outlived its parent's deadline")
+	}
+	if err := i.Err(); err != context.DeadlineExceeded {
+		t.Errorf("Err = %v, want the parent's context.DeadlineExceeded", err)
+	}
+}
+
+func TestParentDeadlineLater(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.Background(), 2*time.Hour)
+	defer cancel()
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(parent, 1*time.Minute, netutil.WithClock(clock.Now))
+	if d, _ := i.Deadline(); !d.Equal(clock.Now().Add(1 * time.Minute)) {
+		t.Errorf("Deadline = %v, want the idle deadline", d)
+	}
+}
+
+// manualTimer is a TimerFactory that only fires on demand.
+type manualTimer struct {
+	mu    sync.Mutex
+	c     chan time.Time
+	armed []time.Duration
+	stops int
+}
+
+func (m *manualTimer) New(d time.Duration) (<-chan time.Time, func(), func(time.Duration) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.c = make(chan time.Time, 1)
+	m.armed = append(m.armed, d)
+	stop := func() {
+		m.mu.Lock()
+		m.stops++
+		m.mu.Unlock()
+	}
+	reset := func(d time.Duration) bool {
+		m.mu.Lock()
+		m.armed = append(m.armed, d)
+		m.mu.Unlock()
+		return false
+	}
+	return m.c, stop, reset
+}
+
+func TestWithTimerFactory(t *testing.T) {
+	clock := newFakeClock()
+	timer := &manualTimer{}
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Minute,
+		netutil.WithClock(clock.Now), netutil.WithTimerFactory(timer.New))
+
+	c := &netutiltest.Conn{}
+	i.ConnState(c, http.StateNew)
+	clock.Advance(1 * time.Hour)
+	i.ConnState(c, http.StateClosed)
+	timer.mu.Lock()
+	armed, stops := timer.armed, timer.stops
+	timer.mu.Unlock()
+	if len(armed) != 2 || armed[0] != 1*time.Minute || armed[1] != 1*time.Minute {
+		t.Errorf("The timer should have been armed twice with the patience, got: %v", armed)
+	}
+	if stops < 1 {
+		t.Error("The timer has not been stopped for the connection")
+	}
+	if isClosed(i.Done()) {
+		t.Fatal("The tracker fired without the timer")
+	}
+
+	clock.Advance(1 * time.Minute)
+	timer.c <- clock.Now()
+	select {
+	case <-i.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("The tracker did not fire on the timer's tick")
+	}
+	if err := i.Err(); err != context.DeadlineExceeded {
+		t.Errorf("Err = %v, want: %v", err, context.DeadlineExceeded)
+	}
+
+	if _, err := netutil.NewIdleTrackerErr(context.Background(), 1*time.Minute, netutil.WithTimerFactory(nil)); err == nil {
+		t.Error("A nil TimerFactory has been accepted")
+	}
+}
+
+func TestConcurrentBookkeeping(t *testing.T) {
+	var news, closes int64
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithConcurrentBookkeeping())
+	churn := func(rounds int, check func()) {
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for n := 0; n < rounds; n++ {
+					c := &netutiltest.Conn{}
+					i.ConnState(c, http.StateNew)
+					i.ConnState(c, http.StateActive)
+					check()
+					i.ConnState(c, http.StateClosed)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	held := &netutiltest.Conn{}
+	i.ConnState(held, http.StateActive)
+	churn(1000, func() {
+		if _, ok := i.Deadline(); ok {
+			t.Error("The tracker is idle with connections")
+		}
+	})
+	if n := i.ActiveConns(); n != 1 || !i.IsTracked(held) {
+		t.Errorf("ActiveConns = %d, want only the held connection", n)
+	}
+	i.ConnState(held, http.StateClosed)
+	if _, ok := i.Deadline(); !ok || i.ActiveConns() != 0 {
+		t.Error("The tracker is not idle without any connection")
+	}
+
+	// Without one held, the first and last go through the lock.
+	churn(1000, func() {})
+	if d, ok := i.Deadline(); !ok || d.Before(time.Now().Add(59*time.Minute)) {
+		t.Errorf("The timer should have been re-armed after the last connection, deadline: %v", d)
+	}
+
+	// Events are emitted for every connection.
+	i = netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithConcurrentBookkeeping(),
+		netutil.WithEventHook(func(ev netutil.Event) {
+			switch ev.Kind {
+			case netutil.EventConnNew:
+				atomic.AddInt64(&news, 1)
+			case netutil.EventConnClosed:
+				atomic.AddInt64(&closes, 1)
+			}
+		}))
+	churn(100, func() {})
+	if news != 800 || closes != 800 {
+		t.Errorf("Got %d EventConnNew and %d EventConnClosed, want 800 each", news, closes)
+	}
+}
+
+func BenchmarkConnStateChurn(b *testing.B) {
+	for _, impl := range []struct {
+		name string
+		opts []netutil.Option
+	}{{"locked", nil}, {"concurrent", []netutil.Option{netutil.WithConcurrentBookkeeping()}}} {
+		b.Run(impl.name, func(b *testing.B) {
+			benchmarkConnStateChurn(b, impl.opts...)
+		})
+	}
+}
+
+func benchmarkConnStateChurn(b *testing.B, opts ...netutil.Option) {
+	for _, tc := range []struct {
+		name    string
+		readers int // Goroutines that call Deadline meanwhile.
+	}{{"churn", 0}, {"churn+deadline", 4}} {
+		b.Run(tc.name, func(b *testing.B) {
+			i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, opts...)
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			for k := 0; k < tc.readers; k++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for !isClosed(stop) {
+						i.Deadline()
+					}
+				}()
+			}
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				c := &netutiltest.Conn{}
+				for pb.Next() {
+					i.ConnState(c, http.StateNew)
+					i.ConnState(c, http.StateActive)
+					i.ConnState(c, http.StateClosed)
+				}
+			})
+			close(stop)
+			wg.Wait()
+		})
+	}
+}
+
+func TestWithRecencyPatience(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		opts    []netutil.Option
+		counted time.Duration // From the last request to the deadline.
+	}{
+		{"full window", nil, 10*time.Minute + 1*time.Hour},
+		{"recency", []netutil.Option{netutil.WithRecencyPatience()}, 1 * time.Hour},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := newFakeClock()
+			opts := append([]netutil.Option{netutil.WithClock(clock.Now), netutil.WithKeepAliveCounts()}, tc.opts...)
+			i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, opts...)
+
+			// A client with periodic requests, of which the last ends at lastRequest.
+			c := &netutiltest.Conn{}
+			i.ConnState(c, http.StateNew)
+			for n := 0; n < 3; n++ {
+				i.ConnState(c, http.StateActive)
+				clock.Advance(1 * time.Second)
+				i.ConnState(c, http.StateIdle)
+				clock.Advance(1 * time.Minute)
+			}
+			lastRequest := clock.Now().Add(-1 * time.Minute)
+			clock.Advance(9 * time.Minute)
+			i.ConnState(c, http.StateClosed)
+
+			if d, _ := i.Deadline(); !d.Equal(lastRequest.Add(tc.counted)) {
+				t.Errorf("Deadline = %v, want: %v", d, lastRequest.Add(tc.counted))
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"net"
+	"time"
+)
+
+// detectLeaks runs the scans set up by WithLeakDetector until done.
+func (t *IdleTracker) detectLeaks() {
+	interval := t.leakMaxAge / 2
+	if interval <= 0 {
+		interval = t.leakMaxAge
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.Done():
+			return
+		case <-ticker.C:
+		}
+		if leaked := t.leakedConns(); len(leaked) > 0 {
+			t.leakObserver(leaked)
+		}
+	}
+}
+
+// leakedConns returns the connections tracked for longer than allowed.
+func (t *IdleTracker) leakedConns() []net.Conn {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var leaked []net.Conn
+	now := t.now()
+	t.dangling.each(func(_ interface{}, ci *connInfo) bool {
+		if now.Sub(ci.since) > t.leakMaxAge {
+			leaked = append(leaked, ci.conn)
+		}
+		return true
+	})
+	return leaked
+}
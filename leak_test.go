@@ -0,0 +1,53 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestLeakDetector(t *testing.T) {
+	clock := newFakeClock()
+	reports := make(chan []net.Conn, 10)
+	parentCtx, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+	i := netutil.NewIdleTracker(parentCtx, 1*time.Hour,
+		netutil.WithClock(clock.Now),
+		netutil.WithLeakDetector(20*time.Millisecond, func(leaked []net.Conn) {
+			reports <- leaked
+		}))
+
+	leaky := &netutiltest.Conn{}
+	i.ConnState(leaky, http.StateNew)
+	i.ConnState(leaky, http.StateActive)
+	clock.Advance(1 * time.Minute)
+	fresh := &netutiltest.Conn{}
+	i.ConnState(fresh, http.StateNew)
+
+	select {
+	case leaked := <-reports:
+		if len(leaked) != 1 || leaked[0] != leaky {
+			t.Errorf("Expected only the never closed connection to be reported, got: %v", leaked)
+		}
+		for _, c := range leaked {
+			i.Evict(c)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The leaked connection has not been reported")
+	}
+
+	if n := i.ActiveConns(); n != 1 {
+		t.Errorf("After evicting the leaked connection one should be left, got: %d", n)
+	}
+	if _, err := netutil.NewIdleTrackerErr(parentCtx, 1*time.Hour,
+		netutil.WithLeakDetector(0, func([]net.Conn) {})); err == nil {
+		t.Error("A non-positive maxAge should have been rejected")
+	}
+}
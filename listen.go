@@ -0,0 +1,19 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// ListenControl is like net.Listen, but calls control on the socket after
+// its creation and before binding it, to set options such as SO_REUSEADDR,
+// IP_FREEBIND, or SO_BINDTODEVICE. See DeferAccept for one such control func.
+func ListenControl(network, address string, control func(network, address string, c syscall.RawConn) error) (net.Listener, error) {
+	lc := net.ListenConfig{Control: control}
+	return lc.Listen(context.Background(), network, address)
+}
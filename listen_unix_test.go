@@ -0,0 +1,67 @@
+// This file is released into the public domain.
+
+//go:build !windows
+// +build !windows
+
+package netutil_test
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func reuseAddr(network, address string, c syscall.RawConn) error {
+	var err error
+	c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	})
+	return err
+}
+
+func TestListenControl(t *testing.T) {
+	var calls int
+	control := func(network, address string, c syscall.RawConn) error {
+		calls++
+		return reuseAddr(network, address, c)
+	}
+
+	ln, err := netutil.ListenControl("tcp", "127.0.0.1:0", control)
+	if err != nil {
+		t.Fatalf("ListenControl: %v", err)
+	}
+	addr := ln.Addr().String()
+
+	// Leave a connection behind, closed by the server first for TIME_WAIT.
+	client, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	conn.Close()
+	client.Close()
+	ln.Close()
+
+	ln, err = netutil.ListenControl("tcp", addr, control)
+	if err != nil {
+		t.Fatalf("Re-binding to %s failed: %v", addr, err)
+	}
+	defer ln.Close()
+	if calls != 2 {
+		t.Errorf("The control func should have been called twice, got: %d", calls)
+	}
+
+	rc, _ := ln.(*net.TCPListener).SyscallConn()
+	var opt int
+	rc.Control(func(fd uintptr) {
+		opt, err = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR)
+	})
+	if err != nil || opt == 0 {
+		t.Errorf("SO_REUSEADDR is not set: %d, %v", opt, err)
+	}
+}
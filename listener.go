@@ -0,0 +1,252 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WrapListener returns a net.Listener that reports the connections it
+// accepts to the IdleTracker, for servers that don't come with a ConnState
+// hook like http.Server does. Connections count as active until closed.
+//
+// Don't use this together with ConnState on the same connections.
+//
+// Only Accept and Close report to the tracker under its lock. Read and Write
+// get by with atomics: they add to the bytes counted in Stats, note when
+// the first byte has been read for Stats.FirstByte and WithFirstByteTimeout,
+// and, with WithPerConnIdle, when the connection has last been in use.
+// Their errors are kept for WithCloseObserver.
+func (t *IdleTracker) WrapListener(ln net.Listener) net.Listener {
+	return &trackingListener{Listener: ln, tracker: t}
+}
+
+// trackingListener implements net.Listener.
+type trackingListener struct {
+	net.Listener
+	tracker *IdleTracker
+}
+
+// Accept implements net.Listener.
+func (l *trackingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tc := &trackedConn{Conn: c, tracker: l.tracker, acceptedAt: time.Now()}
+	if d := l.tracker.perConnIdle; d > 0 {
+		tc.lastActive = time.Now().UnixNano()
+		tc.mu.Lock() // closeIfIdle could run before this has been set.
+		tc.idleTimer = time.AfterFunc(d, tc.closeIfIdle)
+		tc.mu.Unlock()
+	}
+	if d := l.tracker.firstByteWait; d > 0 {
+		tc.mu.Lock()
+		tc.firstByteTimer = time.AfterFunc(d, tc.closeIfSilent)
+		tc.mu.Unlock()
+	}
+	if d := l.tracker.livenessInterval; d > 0 {
+		tc.mu.Lock()
+		tc.probeTimer = time.AfterFunc(d, tc.closeIfDead)
+		tc.mu.Unlock()
+	}
+	l.tracker.ConnState(tc, http.StateNew)
+	l.tracker.bindConn(tc)
+	return tc, nil
+}
+
+// bindConn has the connection carry the tracker's deadline, if so configured.
+func (t *IdleTracker) bindConn(c *trackedConn) {
+	if t.boundConns == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.boundConns[c] = struct{}{}
+	t.syncConnDeadlines()
+	c.Conn.SetDeadline(t.boundDeadline)
+}
+
+// unbindConn undoes bindConn.
+func (t *IdleTracker) unbindConn(c *trackedConn) {
+	if t.boundConns == nil {
+		return
+	}
+	t.mu.Lock()
+	delete(t.boundConns, c)
+	t.mu.Unlock()
+}
+
+// syncConnDeadlines sets the tracker's deadline on the connections
+// bound by WithConnDeadlines, if it has moved.
+// Must be called with the write lock held.
+func (t *IdleTracker) syncConnDeadlines() {
+	if t.boundConns == nil {
+		return
+	}
+	deadline, _ := t.deadlineLocked()
+	if deadline.Equal(t.boundDeadline) {
+		return
+	}
+	t.boundDeadline = deadline
+	for c := range t.boundConns {
+		c.Conn.SetDeadline(deadline)
+	}
+}
+
+// trackedConn reports its end to the tracker.
+type trackedConn struct {
+	lastActive int64 // In UnixNano, accessed atomically, see WithPerConnIdle.
+	firstByte  int64 // Since acceptedAt, accessed atomically; 0 until then.
+
+	net.Conn
+	tracker        *IdleTracker
+	acceptedAt     time.Time
+	idleTimer      *time.Timer
+	firstByteTimer *time.Timer
+	probeTimer     *time.Timer
+
+	closeOnce sync.Once
+	mu        sync.Mutex
+	lastErr   error
+}
+
+// Read implements net.Conn.
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.tracker.bytesIn, int64(n))
+	if n > 0 && atomic.LoadInt64(&c.firstByte) == 0 {
+		c.recordFirstByte()
+	}
+	c.touch()
+	if err != nil {
+		c.recordErr(err)
+	}
+	return n, err
+}
+
+// Write implements net.Conn.
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.tracker.bytesOut, int64(n))
+	c.touch()
+	if err != nil {
+		c.recordErr(err)
+	}
+	return n, err
+}
+
+// touch records activity, if needed.
+func (c *trackedConn) touch() {
+	if c.idleTimer != nil {
+		atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+	}
+}
+
+// closeIfIdle closes the connection if there has been no activity
+// for the time set by WithPerConnIdle, else checks again once there might be.
+func (c *trackedConn) closeIfIdle() {
+	d := c.tracker.perConnIdle
+	idle := time.Since(time.Unix(0, atomic.LoadInt64(&c.lastActive)))
+	c.mu.Lock()
+	if idle < d {
+		c.idleTimer.Reset(d - idle)
+		c.mu.Unlock()
+		return
+	}
+	c.lastErr = ErrConnIdle
+	c.mu.Unlock()
+	c.Close()
+}
+
+// closeIfDead closes the connection if its link seems dead,
+// else checks again later, see WithLivenessProbe.
+func (c *trackedConn) closeIfDead() {
+	if linkAlive(c.Conn, c.tracker.livenessInterval) {
+		c.mu.Lock()
+		c.probeTimer.Reset(c.tracker.livenessInterval)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Lock()
+	c.lastErr = ErrLinkDead
+	c.mu.Unlock()
+	c.Close()
+}
+
+func (c *trackedConn) recordErr(err error) {
+	if c.tracker.closeObserver == nil {
+		return
+	}
+	c.mu.Lock()
+	c.lastErr = err
+	c.mu.Unlock()
+}
+
+// Close implements net.Conn.
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		if c.idleTimer != nil {
+			c.idleTimer.Stop()
+		}
+		if c.firstByteTimer != nil {
+			c.firstByteTimer.Stop()
+		}
+		if c.probeTimer != nil {
+			c.probeTimer.Stop()
+		}
+		c.mu.Unlock()
+		c.tracker.unbindConn(c)
+		c.tracker.ConnState(c, http.StateClosed)
+		if fn := c.tracker.closeObserver; fn != nil {
+			c.mu.Lock()
+			reason := c.lastErr
+			c.mu.Unlock()
+			fn(c, reason)
+		}
+	})
+	return err
+}
+
+// SelfClosingListener returns a net.Listener that closes ln once the tracker
+// is done, so that Serve returns without any goroutine having to wait for it.
+// Connections accepted until then are left alone to finish.
+//
+// It doesn't report connections to the tracker on its own; wrap the result
+// in WrapListener, or have the server use ConnState, for that.
+func (t *IdleTracker) SelfClosingListener(ln net.Listener) net.Listener {
+	l := &selfClosingListener{Listener: ln, closed: make(chan struct{})}
+	go func() {
+		select {
+		case <-t.Done():
+			l.Close()
+		case <-l.closed:
+		}
+	}()
+	return l
+}
+
+// selfClosingListener implements net.Listener.
+type selfClosingListener struct {
+	net.Listener
+	closeOnce sync.Once
+	closeErr  error
+	closed    chan struct{}
+}
+
+// Close implements net.Listener.
+func (l *selfClosingListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.closeErr = l.Listener.Close()
+	})
+	return l.closeErr
+}
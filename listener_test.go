@@ -0,0 +1,481 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestWrapListener(t *testing.T) {
+	parentCtx, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+	i := netutil.NewIdleTracker(parentCtx, 50*time.Millisecond)
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ln = i.WrapListener(ln)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	if _, onDeadline := i.Deadline(); onDeadline {
+		t.Error("With an accepted connection, IdleTracker should not be on a deadline")
+	}
+
+	<-time.After(60 * time.Millisecond)
+	select {
+	case <-i.Done():
+		t.Fatal("IdleTracker is done, although a connection is still open")
+	default:
+	}
+
+	conn.Close()
+	if _, onDeadline := i.Deadline(); !onDeadline {
+		t.Error("After the last connection has been closed, IdleTracker should be on a deadline")
+	}
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Error("IdleTracker is not done after its patience ran out")
+	}
+}
+
+// serveKeepAlive is a stand-in for servers like fasthttp's: it answers
+// every line with "ok" on the same connection, until the client closes it
+// or sends nothing for idleTimeout.
+func serveKeepAlive(ln net.Listener, idleTimeout time.Duration) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			r := bufio.NewReader(c)
+			for {
+				c.SetReadDeadline(time.Now().Add(idleTimeout))
+				if _, err := r.ReadString('\n'); err != nil {
+					return
+				}
+				if _, err := io.WriteString(c, "ok\n"); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func TestWrapListenerKeepAlive(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 50*time.Millisecond)
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer ln.Close()
+	go serveKeepAlive(i.WrapListener(ln), 100*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for k := 0; k < 8; k++ {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			c, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Errorf("net.Dial: %v", err)
+				return
+			}
+			defer c.Close()
+			r := bufio.NewReader(c)
+			for n := 0; n < 50; n++ {
+				io.WriteString(c, "GET\n")
+				if k%4 == 1 && n == 10 {
+					return // Gone before the response.
+				}
+				if _, err := r.ReadString('\n'); err != nil {
+					t.Errorf("Request %d on connection %d: %v", n, k, err)
+					return
+				}
+				if _, idle := i.Deadline(); idle {
+					t.Error("The tracker is idle while connections are open")
+				}
+			}
+			if k%4 == 2 {
+				r.ReadString('\n') // Until the server closes the idle connection.
+			}
+		}(k)
+	}
+	wg.Wait()
+
+	select {
+	case <-i.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatalf("The tracker has not fired after the last connection, %d still tracked", i.ActiveConns())
+	}
+	if n := i.ActiveConns(); n != 0 {
+		t.Errorf("ActiveConns = %d after all connections have been closed", n)
+	}
+	if s := i.Stats(); s.ConnStates[http.StateNew] != 8 || s.ConnStates[http.StateClosed] != 8 {
+		t.Errorf("Counted %d new and %d closed connections, want 8 each",
+			s.ConnStates[http.StateNew], s.ConnStates[http.StateClosed])
+	}
+}
+
+// nullConn reads zeroes and writes to nowhere.
+type nullConn struct {
+	netutiltest.Conn
+}
+
+func (*nullConn) Read(b []byte) (int, error) { return len(b), nil }
+
+// wrapIdleConns has a tracker, configured by opts, track idleConns
+// connections accepted through WrapListener, and returns n more.
+func wrapIdleConns(idleConns, n int, opts ...netutil.Option) []net.Conn {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Minute, opts...)
+	script := make([]interface{}, 0, idleConns+n)
+	for k := 0; k < idleConns+n; k++ {
+		script = append(script, &nullConn{})
+	}
+	ln := i.WrapListener(newScriptedListener(script...))
+	for k := 0; k < idleConns; k++ {
+		ln.Accept()
+	}
+	conns := make([]net.Conn, n)
+	for k := range conns {
+		conns[k], _ = ln.Accept()
+	}
+	return conns
+}
+
+func BenchmarkWrapListener(b *testing.B) {
+	const idleConns = 10000
+	for _, tc := range []struct {
+		name string
+		conn net.Conn
+	}{
+		{"bare", &nullConn{}},
+		{"wrapped", wrapIdleConns(idleConns, 1)[0]},
+		{"wrapped-per-conn-idle", wrapIdleConns(idleConns, 1, netutil.WithPerConnIdle(1*time.Hour))[0]},
+	} {
+		buf := make([]byte, 512)
+		b.Run(tc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				tc.conn.Read(buf)
+				tc.conn.Write(buf)
+			}
+		})
+	}
+
+	// All connections add to the same counters of the tracker.
+	b.Run("wrapped-parallel", func(b *testing.B) {
+		conns := wrapIdleConns(idleConns, 256)
+		var next int32
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			c := conns[int(atomic.AddInt32(&next, 1)-1)%len(conns)]
+			buf := make([]byte, 512)
+			for pb.Next() {
+				c.Read(buf)
+				c.Write(buf)
+			}
+		})
+	})
+}
+
+func TestSelfClosingListener(t *testing.T) {
+	parentCtx, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+	i := netutil.NewIdleTracker(parentCtx, 1*time.Hour)
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ln = i.SelfClosingListener(ln)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept before the tracker fired: %v", err)
+	}
+	defer conn.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		c, err := ln.Accept()
+		if c != nil {
+			c.Close()
+		}
+		accepted <- err
+	}()
+	cancelParent()
+	select {
+	case err := <-accepted:
+		if err == nil {
+			t.Error("Accept should have returned an error after the tracker fired")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Accept still blocks after the tracker fired")
+	}
+
+	// The connection accepted before is still usable.
+	go client.Write([]byte("ping"))
+	if _, err := io.ReadFull(conn, make([]byte, 4)); err != nil {
+		t.Errorf("Read from an in-flight connection: %v", err)
+	}
+}
+
+func TestWrapListenerByteCounts(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Minute)
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ln = i.WrapListener(ln)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	defer conn.Close()
+
+	client.Write(make([]byte, 1000))
+	if _, err := io.ReadFull(conn, make([]byte, 1000)); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	conn.Write(make([]byte, 24))
+	conn.Write(make([]byte, 100))
+
+	s := i.Stats()
+	if s.BytesIn != 1000 || s.BytesOut != 124 {
+		t.Errorf("BytesIn/BytesOut = %d/%d, want 1000/124", s.BytesIn, s.BytesOut)
+	}
+}
+
+func TestPerConnIdle(t *testing.T) {
+	reasons := make(chan error, 2)
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+		netutil.WithPerConnIdle(100*time.Millisecond),
+		netutil.WithCloseObserver(func(_ net.Conn, reason error) { reasons <- reason }))
+	inner, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ln := i.WrapListener(inner)
+	defer ln.Close()
+
+	dialAccept := func() (client, server net.Conn) {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("net.Dial: %v", err)
+		}
+		server, err = ln.Accept()
+		if err != nil {
+			t.Fatalf("Accept: %v", err)
+		}
+		return client, server
+	}
+	activeClient, active := dialAccept()
+	defer activeClient.Close()
+	defer active.Close()
+	stalledClient, stalled := dialAccept()
+	defer stalledClient.Close()
+	defer stalled.Close()
+
+	for n := 0; n < 10; n++ {
+		activeClient.Write([]byte("x"))
+		if _, err := active.Read(make([]byte, 1)); err != nil {
+			t.Fatalf("The active connection has been closed: %v", err)
+		}
+		time.Sleep(30 * time.Millisecond)
+	}
+
+	select {
+	case reason := <-reasons:
+		if reason != netutil.ErrConnIdle {
+			t.Errorf("The stalled connection should have been closed for being idle, got: %v", reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The stalled connection has not been closed")
+	}
+	if n := i.ActiveConns(); n != 1 {
+		t.Errorf("Only the active connection should be left, got: %d", n)
+	}
+}
+
+func TestFirstByteLatency(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	inner, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ln := i.WrapListener(inner)
+	defer ln.Close()
+
+	const delay = 50 * time.Millisecond
+	go func() {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		time.Sleep(delay)
+		client.Write([]byte("x"))
+		io.Copy(ioutil.Discard, client)
+	}()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	defer conn.Close()
+	if _, ok := netutil.FirstByteLatency(conn); ok {
+		t.Error("FirstByteLatency reports a latency before anything has been read")
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	ttfb, ok := netutil.FirstByteLatency(conn)
+	if !ok || ttfb < delay || ttfb > 20*delay {
+		t.Errorf("FirstByteLatency = %v, %v; want about %v", ttfb, ok, delay)
+	}
+	var total int64
+	for k, n := range i.Stats().FirstByte {
+		total += n
+		if n > 0 && k > 0 && ttfb <= netutil.FirstByteBounds[k-1] {
+			t.Errorf("Bucket %d counts a latency of %v", k, ttfb)
+		}
+	}
+	if total != 1 {
+		t.Errorf("Stats.FirstByte counts %d connections, want 1", total)
+	}
+	if _, ok := netutil.FirstByteLatency(&netutiltest.Conn{}); ok {
+		t.Error("FirstByteLatency reports a latency for a connection not accepted through WrapListener")
+	}
+}
+
+func TestFirstByteTimeout(t *testing.T) {
+	reasons := make(chan error, 1)
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+		netutil.WithFirstByteTimeout(50*time.Millisecond),
+		netutil.WithCloseObserver(func(_ net.Conn, reason error) { reasons <- reason }))
+	inner, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ln := i.WrapListener(inner)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer client.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case reason := <-reasons:
+		if reason != netutil.ErrNoFirstByte {
+			t.Errorf("The silent connection should have been closed for ErrNoFirstByte, got: %v", reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The silent connection has not been closed")
+	}
+	if n := i.ActiveConns(); n != 0 {
+		t.Errorf("The silent connection is still being tracked")
+	}
+}
+
+// deadlineConn records the deadline last set.
+type deadlineConn struct {
+	netutiltest.Conn
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (c *deadlineConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *deadlineConn) Deadline() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deadline
+}
+
+func TestWithConnDeadlines(t *testing.T) {
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithClock(clock.Now),
+		netutil.WithIgnoreLoopback(), netutil.WithConnDeadlines())
+	agent := &deadlineConn{Conn: netutiltest.Conn{Remote: &net.TCPAddr{IP: net.IPv6loopback, Port: 4711}}}
+	ln := i.WrapListener(newScriptedListener(agent))
+	defer ln.Close()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	defer c.Close()
+
+	want, _ := i.Deadline()
+	if got := agent.Deadline(); !got.Equal(want) {
+		t.Errorf("The lingering connection's deadline is %v, want the service's: %v", got, want)
+	}
+
+	// While busy there is no deadline, which moves once idle again.
+	client := newRemoteConn(t, "192.0.2.1:4711")
+	i.ConnState(client, http.StateNew)
+	if got := agent.Deadline(); !got.IsZero() {
+		t.Errorf("The deadline has not been cleared while busy, got: %v", got)
+	}
+	clock.Advance(10 * time.Minute)
+	i.ConnState(client, http.StateClosed)
+	want, _ = i.Deadline()
+	if got := agent.Deadline(); !got.Equal(want) || !got.Equal(clock.Now().Add(1*time.Hour)) {
+		t.Errorf("The deadline did not follow the service's, got: %v, want: %v", got, want)
+	}
+
+	if err := i.Reconfigure(netutil.WithPatience(30 * time.Minute)); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	if got := agent.Deadline(); !got.Equal(clock.Now().Add(30 * time.Minute)) {
+		t.Errorf("The deadline did not follow Reconfigure, got: %v", got)
+	}
+}
@@ -0,0 +1,70 @@
+// This file is released into the public domain.
+
+//go:build !windows
+// +build !windows
+
+package netutil_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func TestCloseObserver(t *testing.T) {
+	type observation struct {
+		conn   net.Conn
+		reason error
+	}
+	observed := make(chan observation, 1)
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Minute,
+		netutil.WithCloseObserver(func(c net.Conn, reason error) {
+			observed <- observation{c, reason}
+		}))
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ln = i.WrapListener(ln)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+
+	// Have the client send a RST instead of a FIN.
+	client.(*net.TCPConn).SetLinger(0)
+	client.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.Copy(ioutil.Discard, conn); err == nil {
+		t.Fatal("Read did not run into the connection reset")
+	}
+	conn.Close()
+	conn.Close() // Must not get reported twice.
+
+	o := <-observed
+	if o.conn != conn {
+		t.Errorf("The observer got called with a different connection: %v", o.conn)
+	}
+	if !errors.Is(o.reason, syscall.ECONNRESET) {
+		t.Errorf("Expected a connection reset as reason, got: %v", o.reason)
+	}
+	select {
+	case o := <-observed:
+		t.Errorf("The observer got called more than once, again with: %v", o.reason)
+	default:
+	}
+}
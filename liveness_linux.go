@@ -0,0 +1,49 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux && !386
+// +build linux,!386
+
+package netutil
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// tcpEstablished is TCP_ESTABLISHED of the kernel's tcp_states.h.
+const tcpEstablished = 1
+
+// linkAlive tells whether c is not a TCP connection whose peer has gone,
+// or whose data sent has not been acknowledged for longer than patience.
+func linkAlive(c net.Conn, patience time.Duration) bool {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return true
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return true
+	}
+	var (
+		info  syscall.TCPInfo
+		errno syscall.Errno
+	)
+	err = rc.Control(func(fd uintptr) {
+		size := uint32(syscall.SizeofTCPInfo)
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd,
+			syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil || errno != 0 {
+		return true // Closed meanwhile, or unknown.
+	}
+	if info.State != tcpEstablished {
+		return false
+	}
+	sinceAck := time.Duration(info.Last_ack_recv) * time.Millisecond
+	return info.Unacked == 0 || sinceAck <= patience
+}
@@ -0,0 +1,70 @@
+// This file is released into the public domain.
+
+//go:build !386
+// +build !386
+
+package netutil_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func TestLivenessProbe(t *testing.T) {
+	reasons := make(chan error, 2)
+	i := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond,
+		netutil.WithLivenessProbe(20*time.Millisecond),
+		netutil.WithCloseObserver(func(_ net.Conn, reason error) { reasons <- reason }))
+	inner, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ln := i.WrapListener(inner)
+	defer ln.Close()
+
+	dialAccept := func() (client, server net.Conn) {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("net.Dial: %v", err)
+		}
+		server, err = ln.Accept()
+		if err != nil {
+			t.Fatalf("Accept: %v", err)
+		}
+		return client, server
+	}
+	liveClient, live := dialAccept()
+	deadClient, dead := dialAccept()
+	defer dead.Close()
+
+	// The server is busy elsewhere, and doesn't read to learn about this.
+	deadClient.Close()
+	select {
+	case reason := <-reasons:
+		if reason != netutil.ErrLinkDead {
+			t.Errorf("The dead connection should have been closed for ErrLinkDead, got: %v", reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The dead connection has not been closed")
+	}
+	if n := i.ActiveConns(); n != 1 {
+		t.Errorf("Only the live connection should be left, got: %d", n)
+	}
+	select {
+	case <-i.Done():
+		t.Fatal("The tracker fired with a live connection")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	liveClient.Close()
+	live.Close()
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("The tracker has not re-armed")
+	}
+}
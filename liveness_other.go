@@ -0,0 +1,18 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux || 386
+// +build !linux 386
+
+package netutil
+
+import (
+	"net"
+	"time"
+)
+
+// linkAlive is not implemented here, hence deems every link alive.
+func linkAlive(net.Conn, time.Duration) bool {
+	return true
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Handler returns a http.Handler that tracks the requests to next.
+// While any is in flight the tracker won't be on a deadline,
+// which gets re-armed once the last one has been served.
+//
+// Unlike ConnState this is oblivious to connections, and thus to
+// keep-alive connections that linger without any requests.
+// Use it instead of ConnState for request-accurate idling.
+// Used together, the service idles once neither requests nor connections are left.
+func (t *IdleTracker) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer t.BeginRequest()()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Limit returns a middleware like Handler that serves at most max requests
+// concurrently, responding to any beyond that with status 503
+// "Service Unavailable". Rejected requests don't count as activity,
+// hence cannot be used to keep the service from idling, unless connections
+// are tracked through ConnState as well. A max of zero or less imposes no limit.
+func (t *IdleTracker) Limit(max int) func(http.Handler) http.Handler {
+	if max <= 0 {
+		return t.Handler
+	}
+	slots := make(chan struct{}, max)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+			default:
+				http.Error(w, "too many requests", http.StatusServiceUnavailable)
+				return
+			}
+			defer func() { <-slots }()
+			defer t.BeginRequest()()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BeginRequest counts a request as in flight until end is called,
+// which is what Handler does for every request. Use this for protocols
+// that multiplex many requests over one connection, such as in a gRPC
+// interceptor, where connections alone tell little about being idle.
+//
+// The tracker won't idle while any request is in flight, and re-arms its
+// timer once the last one has ended. Calling end more than once has no effect.
+func (t *IdleTracker) BeginRequest() (end func()) {
+	t.mu.Lock()
+	if t.busy() == 0 {
+		t.stopIdling()
+	}
+	t.inflight++
+	t.everActive = true
+	t.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			t.inflight--
+			if now := t.now(); now.After(t.lastActive) {
+				t.lastActive = now
+			}
+			if t.busy() == 0 {
+				t.startIdling()
+			}
+			t.mu.Unlock()
+		})
+	}
+}
+
+// DeadlineHeaderMiddleware returns a http.Handler that adds the header
+// "X-Idle-Deadline" to the responses of next, for debugging. Its value is
+// the deadline as Deadline returns it, formatted according to RFC3339,
+// or "active" if there is none because the tracker is busy. Hence a parent's
+// deadline shows even while busy.
+//
+// The deadline is read before next is called. Any request tracked by ConnState
+// or Handler will find the tracker active, of course.
+func (t *IdleTracker) DeadlineHeaderMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := "active"
+		if deadline, ok := t.Deadline(); ok {
+			value = deadline.UTC().Format(time.RFC3339)
+		}
+		w.Header().Set("X-Idle-Deadline", value)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,171 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestHandlerOverlappingRequests(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 50*time.Millisecond)
+	release := make(map[string]chan struct{})
+	for _, path := range []string{"/a", "/b"} {
+		release[path] = make(chan struct{})
+	}
+	entered := make(chan struct{}, 2)
+	h := i.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release[r.URL.Path]
+	}))
+
+	var wg sync.WaitGroup
+	for path := range release {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		}(path)
+	}
+	<-entered
+	<-entered
+	if _, onDeadline := i.Deadline(); onDeadline {
+		t.Error("With requests in flight, IdleTracker should not be on a deadline")
+	}
+
+	close(release["/a"])
+	<-time.After(80 * time.Millisecond)
+	if _, onDeadline := i.Deadline(); onDeadline {
+		t.Error("With one request left in flight, IdleTracker should not be on a deadline")
+	}
+	if isClosed(i.Done()) {
+		t.Fatal("IdleTracker is done, although a request is still in flight")
+	}
+
+	close(release["/b"])
+	wg.Wait()
+	if _, onDeadline := i.Deadline(); !onDeadline {
+		t.Error("After the last request, IdleTracker should be on a deadline")
+	}
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Error("IdleTracker is not done after the last request has been served")
+	}
+}
+
+func TestDeadlineHeaderMiddleware(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	h := i.DeadlineHeaderMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	got, err := time.Parse(time.RFC3339, rec.Header().Get("X-Idle-Deadline"))
+	if err != nil {
+		t.Fatalf("While idle, the header should be a timestamp: %v", err)
+	}
+	if want, _ := i.Deadline(); got.Unix() != want.Unix() {
+		t.Errorf("X-Idle-Deadline = %v, want %v", got, want)
+	}
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("The middleware interfered with the response, status: %d", rec.Code)
+	}
+
+	conn := &netutiltest.Conn{}
+	i.ConnState(conn, http.StateActive)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if got := rec.Header().Get("X-Idle-Deadline"); got != "active" {
+		t.Errorf("While active, X-Idle-Deadline = %q, want \"active\"", got)
+	}
+}
+
+func TestDeadlineHeaderMiddlewareParent(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	i := netutil.NewIdleTracker(parent, 1*time.Hour)
+	h := i.DeadlineHeaderMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	i.ConnState(&netutiltest.Conn{}, http.StateActive)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	got, err := time.Parse(time.RFC3339, rec.Header().Get("X-Idle-Deadline"))
+	if err != nil {
+		t.Fatalf("While active under a parent with a deadline, the header should be a timestamp: %v", err)
+	}
+	if want, _ := parent.Deadline(); got.Unix() != want.Unix() {
+		t.Errorf("X-Idle-Deadline = %v, want the parent's %v", got, want)
+	}
+}
+
+func TestBeginRequestOverlapping(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond)
+	endA := i.BeginRequest()
+	endB := i.BeginRequest()
+	if _, ok := i.Deadline(); ok {
+		t.Error("There should be no deadline with requests in flight")
+	}
+
+	endA()
+	endA() // Must not count as the end of B.
+	select {
+	case <-i.Done():
+		t.Fatal("The tracker fired with a request still in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	endB()
+	if _, ok := i.Deadline(); !ok {
+		t.Error("The timer should have been re-armed after the last request")
+	}
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("The tracker has not fired after all requests ended")
+	}
+}
+
+func TestLimit(t *testing.T) {
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithClock(clock.Now))
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	h := i.Limit(1)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	}))
+	accepted := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		accepted <- rec.Code
+	}()
+	<-entered
+	if _, onDeadline := i.Deadline(); onDeadline {
+		t.Error("The accepted request should keep the tracker from idling")
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("A request beyond the limit got status %d, want: %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	clock.Advance(1 * time.Minute)
+	close(release)
+	if code := <-accepted; code != http.StatusOK {
+		t.Errorf("The accepted request got status %d", code)
+	}
+	if d, _ := i.Deadline(); !d.Equal(clock.Now().Add(1 * time.Hour)) {
+		t.Errorf("The deadline has not been re-armed after the request, %v, got: %v", clock.Now().Add(1*time.Hour), d)
+	}
+
+}
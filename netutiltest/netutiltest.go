@@ -0,0 +1,78 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package netutiltest provides utilities for testing code
+// that uses package netutil, without any real network connections.
+package netutiltest
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+// Conn is a net.Conn that is not connected to anything.
+// Reads end right away and writes go nowhere.
+//
+// Every instance is distinct, hence can be told apart when used as map key.
+type Conn struct {
+	// Local and Remote are returned by LocalAddr and RemoteAddr.
+	Local, Remote net.Addr
+}
+
+var _ net.Conn = &Conn{}
+
+// Read implements net.Conn.
+func (*Conn) Read([]byte) (int, error) { return 0, io.EOF }
+
+// Write implements net.Conn.
+func (*Conn) Write(b []byte) (int, error) { return len(b), nil }
+
+// Close implements net.Conn.
+func (*Conn) Close() error { return nil }
+
+// LocalAddr implements net.Conn.
+func (c *Conn) LocalAddr() net.Addr { return c.Local }
+
+// RemoteAddr implements net.Conn.
+func (c *Conn) RemoteAddr() net.Addr { return c.Remote }
+
+// SetDeadline implements net.Conn.
+func (*Conn) SetDeadline(time.Time) error { return nil }
+
+// SetReadDeadline implements net.Conn.
+func (*Conn) SetReadDeadline(time.Time) error { return nil }
+
+// SetWriteDeadline implements net.Conn.
+func (*Conn) SetWriteDeadline(time.Time) error { return nil }
+
+// DriveConnState feeds the script to t.ConnState as if a http.Server did.
+//
+// Every http.StateNew introduces another Conn, and any other state applies
+// to the most recent one still open. Its http.StateClosed or
+// http.StateHijacked ends it, so that the next state applies to the
+// one opened before. Conns don't carry over from one call to the next.
+func DriveConnState(t *netutil.IdleTracker, script []http.ConnState) {
+	var open []*Conn
+	for _, state := range script {
+		if state == http.StateNew || len(open) == 0 {
+			open = append(open, &Conn{})
+		}
+		c := open[len(open)-1]
+		t.ConnState(c, state)
+		if state == http.StateClosed || state == http.StateHijacked {
+			open = open[:len(open)-1]
+		}
+	}
+}
+
+// ForceFire has t be done right away with reason, or
+// context.DeadlineExceeded if nil, as if it had idled out.
+// Use this to test shutdown logic without waiting for the patience.
+func ForceFire(t *netutil.IdleTracker, reason error) {
+	t.Fire(reason)
+}
@@ -0,0 +1,79 @@
+// This file is released into the public domain.
+
+package netutiltest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestDistinctConns(t *testing.T) {
+	a, b := &netutiltest.Conn{}, &netutiltest.Conn{}
+	if a == b {
+		t.Fatal("Two instances of Conn compare equal")
+	}
+}
+
+func TestDriveConnState(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Minute)
+
+	netutiltest.DriveConnState(i, []http.ConnState{
+		http.StateNew, http.StateActive,
+		http.StateNew, http.StateActive, http.StateIdle, http.StateClosed,
+	})
+	if _, onDeadline := i.Deadline(); onDeadline {
+		t.Fatal("The first connection is still active, but IdleTracker is on a deadline")
+	}
+
+	i = netutil.NewIdleTracker(context.Background(), 1*time.Minute)
+	netutiltest.DriveConnState(i, []http.ConnState{
+		http.StateNew, http.StateActive,
+		http.StateNew, http.StateActive, http.StateIdle, http.StateClosed,
+		http.StateIdle, http.StateClosed,
+	})
+	if _, onDeadline := i.Deadline(); !onDeadline {
+		t.Fatal("All connections are closed, but IdleTracker is not on a deadline")
+	}
+}
+
+func TestForceFire(t *testing.T) {
+	var realEvents, forcedEvents []netutil.Event
+	real := netutil.NewIdleTracker(context.Background(), 10*time.Millisecond,
+		netutil.WithEventHook(func(ev netutil.Event) { realEvents = append(realEvents, ev) }))
+	forced := netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+		netutil.WithEventHook(func(ev netutil.Event) { forcedEvents = append(forcedEvents, ev) }))
+
+	select {
+	case <-real.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("The tracker has not fired on its own")
+	}
+	netutiltest.ForceFire(forced, nil)
+	select {
+	case <-forced.Done():
+	default:
+		t.Fatal("ForceFire has not closed Done")
+	}
+	if real.Err() != forced.Err() {
+		t.Errorf("Err = %v, a real fire results in %v", forced.Err(), real.Err())
+	}
+	last := func(evs []netutil.Event) netutil.Event { return evs[len(evs)-1] }
+	if r, f := last(realEvents), last(forcedEvents); r.Kind != f.Kind || r.Err != f.Err {
+		t.Errorf("Last event = %v %v, a real fire results in %v %v", f.Kind, f.Err, r.Kind, r.Err)
+	}
+
+	// The reason is kept, and the first one wins.
+	errShutdown := errors.New("shutdown")
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	netutiltest.ForceFire(i, errShutdown)
+	netutiltest.ForceFire(i, nil)
+	if err := i.Err(); err != errShutdown {
+		t.Errorf("Err = %v, want the reason given first", err)
+	}
+}
@@ -0,0 +1,434 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Option configures an IdleTracker on construction,
+// and some of them at runtime through Reconfigure.
+type Option func(*IdleTracker) error
+
+// ErrNotReconfigurable is returned by Reconfigure for options
+// that can only be set on construction.
+var ErrNotReconfigurable = errors.New("netutil: option cannot be changed at runtime")
+
+// fixed guards an option that only applies on construction.
+func (t *IdleTracker) fixed(name string) error {
+	if t.running {
+		return fmt.Errorf("netutil: %s: %w", name, ErrNotReconfigurable)
+	}
+	return nil
+}
+
+// WithPatience replaces the patience the tracker has been created with.
+// It is meant for Reconfigure.
+func WithPatience(d time.Duration) Option {
+	return func(t *IdleTracker) error {
+		if d <= 0 {
+			return ErrInvalidPatience
+		}
+		t.patience = d
+		return nil
+	}
+}
+
+// WithTimerFactory replaces the timer that fires once patience runs out.
+//
+// Together with WithClock this allows tests to drive the tracker
+// without waiting: send on the returned channel to have it fire.
+// The timer of WithMaxDeadline is not affected.
+func WithTimerFactory(factory TimerFactory) Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithTimerFactory"); err != nil {
+			return err
+		}
+		if factory == nil {
+			return errors.New("netutil: WithTimerFactory needs a non-nil factory")
+		}
+		t.timerFactory = factory
+		return nil
+	}
+}
+
+// WithClock replaces time.Now as source of the current time.
+//
+// The clock is used for the deadline and any accounting, such as in Stats,
+// but not by the timer that eventually fires; see WithTimerFactory for that.
+// Use this in tests.
+func WithClock(now func() time.Time) Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithClock"); err != nil {
+			return err
+		}
+		if now == nil {
+			return errors.New("netutil: WithClock needs a non-nil clock")
+		}
+		t.now = now
+		return nil
+	}
+}
+
+// WithCloseObserver has connections accepted through WrapListener call fn
+// once they are closed. The reason is the last error any Read or Write
+// ran into, such as io.EOF or a connection reset, or nil if there was none.
+func WithCloseObserver(fn func(c net.Conn, reason error)) Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithCloseObserver"); err != nil {
+			return err
+		}
+		t.closeObserver = fn
+		return nil
+	}
+}
+
+// WithIgnoreLoopback excludes connections from loopback addresses,
+// such as monitoring agents on the same host, from the tracking.
+// They neither postpone the deadline nor keep the service alive,
+// but still get served as usual.
+func WithIgnoreLoopback() Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithIgnoreLoopback"); err != nil {
+			return err
+		}
+		t.ignoreLoopback = true
+		return nil
+	}
+}
+
+// WithCumulativeIdle has the tracker fire once the idle periods add up to
+// total, even if none of them has lasted for the patience on its own.
+// The rolling window of patience still applies.
+//
+// Use this to cap how long a worker may sit idle over its entire lifetime.
+func WithCumulativeIdle(total time.Duration) Option {
+	return func(t *IdleTracker) error {
+		if total <= 0 {
+			return errors.New("netutil: WithCumulativeIdle needs a positive duration")
+		}
+		t.cumulativeIdle = total
+		return nil
+	}
+}
+
+// WithConnKey has connections be told apart by what fn returns, which must
+// be comparable, instead of by the net.Conn itself.
+//
+// Use this if the state changes of any one connection could get reported
+// with different wrappers around it, such as with TLS or PROXY protocol
+// layered differently. Else a connection could stay tracked forever.
+// ConnAddrKey is a suitable fn.
+func WithConnKey(fn func(net.Conn) interface{}) Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithConnKey"); err != nil {
+			return err
+		}
+		if fn == nil {
+			return errors.New("netutil: WithConnKey needs a non-nil func")
+		}
+		t.connKey = fn
+		return nil
+	}
+}
+
+// ConnAddrKey identifies a connection by its local and remote address.
+func ConnAddrKey(c net.Conn) interface{} {
+	type addrPair struct{ local, remote string }
+	var k addrPair
+	if a := c.LocalAddr(); a != nil {
+		k.local = a.Network() + ":" + a.String()
+	}
+	if a := c.RemoteAddr(); a != nil {
+		k.remote = a.Network() + ":" + a.String()
+	}
+	return k
+}
+
+// WithWarnBefore sets the window before the deadline in which the tracker
+// no longer reports being Ready, for load balancers to stop sending
+// new requests ahead of it firing.
+func WithWarnBefore(d time.Duration) Option {
+	return func(t *IdleTracker) error {
+		if d < 0 {
+			return errors.New("netutil: WithWarnBefore needs a non-negative duration")
+		}
+		t.warnBefore = d
+		return nil
+	}
+}
+
+// WithAdaptivePatience replaces the fixed patience by one between min and max,
+// depending on the rate of recent connections: the busier the service has
+// been, the longer it waits for more to come. Quiet services idle out after min.
+//
+// Each new connection counts for one, which halves every max.
+// The patience then is min + (max-min) * n/(n+1) for a count of n.
+func WithAdaptivePatience(min, max time.Duration) Option {
+	return func(t *IdleTracker) error {
+		if min <= 0 || max < min {
+			return errors.New("netutil: WithAdaptivePatience needs 0 < min <= max")
+		}
+		t.adaptive = &adaptivePatience{min: min, max: max}
+		return nil
+	}
+}
+
+// WithSpikeShutdown has the tracker fire with ErrAcceptSpike once more than
+// threshold connections have been new within window, as a last resort
+// against being overrun, leaving it to a supervisor to back off or restart.
+// Connections ignored by WithIgnoreLoopback don't count.
+func WithSpikeShutdown(threshold int, window time.Duration) Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithSpikeShutdown"); err != nil {
+			return err
+		}
+		if threshold < 1 || window <= 0 {
+			return errors.New("netutil: WithSpikeShutdown needs a positive threshold and window")
+		}
+		t.spike = &spikeDetector{window: window, times: make([]time.Time, threshold)}
+		return nil
+	}
+}
+
+// WithLeakDetector has the tracker look for connections that have been
+// tracked for longer than maxAge, every maxAge/2 until it is done, and
+// report them to fn. Those are usually connections of which the end has
+// never been reported, and which keep the service from idling forever.
+// fn gets called from a goroutine of its own, and can Evict them.
+//
+// Connections get reported time and again until gone.
+func WithLeakDetector(maxAge time.Duration, fn func([]net.Conn)) Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithLeakDetector"); err != nil {
+			return err
+		}
+		if maxAge <= 0 || fn == nil {
+			return errors.New("netutil: WithLeakDetector needs a positive maxAge and a non-nil func")
+		}
+		t.leakMaxAge, t.leakObserver = maxAge, fn
+		return nil
+	}
+}
+
+// FirePolicy decides what happens if the patience has run out, but some
+// connection has arrived meanwhile, such as while the func set by
+// SetPreShutdown ran, or in a race with the timer.
+type FirePolicy int
+
+const (
+	// FireLinger has the tracker be done, leaving connections be.
+	// This is the default.
+	FireLinger FirePolicy = iota
+	// FireWait has the tracker wait for the connections to be gone,
+	// and then for the patience again. Anything set by SetPreShutdown runs again.
+	FireWait
+	// FireClose has the tracker be done, then close any tracked connection.
+	FireClose
+)
+
+// WithFirePolicy sets what to do about connections that arrive too late.
+func WithFirePolicy(p FirePolicy) Option {
+	return func(t *IdleTracker) error {
+		if p < FireLinger || p > FireClose {
+			return errors.New("netutil: WithFirePolicy needs a known FirePolicy")
+		}
+		t.firePolicy = p
+		return nil
+	}
+}
+
+// WithKeepAliveCounts has keep-alive connections that sit idle between
+// requests, in http.StateIdle, keep the service alive as well until closed.
+// By default only connections with a request in flight do.
+//
+// Pair this with server.IdleTimeout, else the service might never idle out,
+// or use CloseIdleConns.
+func WithKeepAliveCounts() Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithKeepAliveCounts"); err != nil {
+			return err
+		}
+		t.keepAliveCounts = true
+		return nil
+	}
+}
+
+// WithRecencyPatience has the patience count from when any connection has
+// last been active, that is had a request in flight, instead of from when
+// the last one is gone. Then a burst of requests followed by silence idles
+// out exactly patience after the last request, even if keep-alive
+// connections lingered in between, see WithKeepAliveCounts.
+// Requests counted through Handler or BeginRequest count as activity, too.
+func WithRecencyPatience() Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithRecencyPatience"); err != nil {
+			return err
+		}
+		t.recencyPatience = true
+		return nil
+	}
+}
+
+// WithDeferredStart keeps the tracker from counting down its patience until
+// the first ConnState, or a call of Start. Until then it has no deadline.
+//
+// Use this if there can be a gap between creating the tracker and serving,
+// such as in activated services, that must not count as idling.
+func WithDeferredStart() Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithDeferredStart"); err != nil {
+			return err
+		}
+		t.awaitingStart = true
+		return nil
+	}
+}
+
+// ErrConnIdle is the reason passed to the func set by WithCloseObserver
+// for connections closed due to WithPerConnIdle.
+var ErrConnIdle = errors.New("netutil: connection idle for too long")
+
+// WithPerConnIdle has connections accepted through WrapListener be closed
+// after d without any Read or Write returning, which ends their tracking.
+// This gets rid of stalled or zombie keep-alive connections
+// that would keep the service alive otherwise.
+//
+// A Read that blocks waiting for data counts as idle.
+func WithPerConnIdle(d time.Duration) Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithPerConnIdle"); err != nil {
+			return err
+		}
+		if d <= 0 {
+			return errors.New("netutil: WithPerConnIdle needs a positive duration")
+		}
+		t.perConnIdle = d
+		return nil
+	}
+}
+
+// WithConnDeadlines has connections accepted through WrapListener carry the
+// tracker's deadline, as returned by Deadline, as their read and write
+// deadline, so that none outlives the service. The deadline gets updated
+// whenever the tracker's moves, and cleared while the tracker has none.
+//
+// Connections that keep the tracker busy see only its parent's deadline,
+// hence this matters for those that don't count, see WithIgnoreLoopback,
+// and the parent's deadline.
+func WithConnDeadlines() Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithConnDeadlines"); err != nil {
+			return err
+		}
+		t.boundConns = make(map[*trackedConn]struct{})
+		return nil
+	}
+}
+
+// WithFirstByteTimeout has connections accepted through WrapListener be
+// closed if they haven't sent anything within d, which ends their tracking.
+// This gets rid of clients that connect, but never send a request, such as
+// in a slow-loris attack. The close observer gets ErrNoFirstByte for them.
+func WithFirstByteTimeout(d time.Duration) Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithFirstByteTimeout"); err != nil {
+			return err
+		}
+		if d <= 0 {
+			return errors.New("netutil: WithFirstByteTimeout needs a positive duration")
+		}
+		t.firstByteWait = d
+		return nil
+	}
+}
+
+// ErrLinkDead is the reason passed to the func set by WithCloseObserver
+// for connections closed due to WithLivenessProbe.
+var ErrLinkDead = errors.New("netutil: connection link is dead")
+
+// WithLivenessProbe has the connections accepted through WrapListener be
+// checked every interval, and closed if their link seems dead, which ends
+// their tracking. That is the case if the peer has gone, or data sent has
+// not been acknowledged for longer than interval. Such connections would
+// otherwise keep the service alive until the kernel gives up on them.
+//
+// This is implemented on Linux only, by TCP_INFO, and for TCP connections.
+// Elsewhere connections are deemed alive.
+func WithLivenessProbe(interval time.Duration) Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithLivenessProbe"); err != nil {
+			return err
+		}
+		if interval <= 0 {
+			return errors.New("netutil: WithLivenessProbe needs a positive interval")
+		}
+		t.livenessInterval = interval
+		return nil
+	}
+}
+
+// WithShutdownBudget has ServeUntilIdle and BindServers try server.Shutdown
+// up to steps times, each getting its share of total, before they resort to
+// server.Close. Anything left of total is passed on to the next attempt.
+// This replaces the drain or grace period they would use otherwise.
+//
+// Should it come to closing, the error returned is a *ShutdownError.
+func WithShutdownBudget(total time.Duration, steps int) Option {
+	return func(t *IdleTracker) error {
+		if total <= 0 || steps < 1 {
+			return errors.New("netutil: WithShutdownBudget needs a positive duration and at least one step")
+		}
+		t.shutdownBudget, t.shutdownSteps = total, steps
+		return nil
+	}
+}
+
+// WithMaxDeadline sets a hard deadline from the start, as a safety valve
+// against the service being kept alive forever. See SetHardDeadline,
+// which can change or remove it later.
+func WithMaxDeadline(at time.Time) Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithMaxDeadline"); err != nil {
+			return err
+		}
+		if at.IsZero() {
+			return errors.New("netutil: WithMaxDeadline needs a non-zero time")
+		}
+		t.hardDeadline = at
+		return nil
+	}
+}
+
+// WithShutdownWriteTimeout sets a write deadline d ahead on the connections
+// still tracked once the tracker is done, so that writes to clients that
+// stopped reading fail instead of stalling server.Shutdown.
+func WithShutdownWriteTimeout(d time.Duration) Option {
+	return func(t *IdleTracker) error {
+		if d <= 0 {
+			return errors.New("netutil: WithShutdownWriteTimeout needs a positive duration")
+		}
+		t.shutdownWriteTimeout = d
+		return nil
+	}
+}
+
+// WithLastRequestGrace delays shutting down the servers bound by BindServers,
+// or served by ServeUntilIdle, to d after any connection has last been active
+// if that has been less than d before the tracker was done.
+// This way a response still being flushed at the idle boundary is not cut off
+// by a short budget set by WithShutdownBudget, or other deadlines.
+func WithLastRequestGrace(d time.Duration) Option {
+	return func(t *IdleTracker) error {
+		if d <= 0 {
+			return errors.New("netutil: WithLastRequestGrace needs a positive duration")
+		}
+		t.lastRequestGrace = d
+		return nil
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrHardDeadline is what Err returns after the deadline set by
+// SetHardDeadline has passed.
+var ErrHardDeadline = errors.New("netutil: hard deadline exceeded")
+
+// Pause suspends the idle timer until Resume, regardless of connections.
+// It doesn't stack: one Resume undoes any number of Pause.
+//
+// Only idling is suspended. The parent and any hard deadline, see
+// SetHardDeadline, still end the tracker.
+func (t *IdleTracker) Pause() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.paused {
+		return
+	}
+	if t.busy() == 0 {
+		t.stopIdling()
+	}
+	t.paused = true
+}
+
+// Resume undoes Pause. If nothing else keeps it busy,
+// the tracker waits for its full patience again.
+func (t *IdleTracker) Resume() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.paused {
+		return
+	}
+	t.paused = false
+	if t.busy() == 0 {
+		t.startIdling()
+	}
+}
+
+// SetHardDeadline has the tracker be done at the latest by deadline,
+// with ErrHardDeadline, no matter whether it is busy or paused.
+// Anything set by SetPreShutdown is skipped, as that is for idling.
+// A zero deadline removes any hard deadline set before.
+//
+// Precedence is by time: whichever of the parent, the idle timer,
+// or the hard deadline comes first decides Err. Any patience that would
+// end later gets cut short, see EventDeadlineClamped, and Deadline
+// reports the hard deadline then.
+func (t *IdleTracker) SetHardDeadline(deadline time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hardTimer != nil {
+		t.hardTimer.Stop()
+		t.hardTimer = nil
+	}
+	wasClamped := !t.hardDeadline.IsZero() && !t.deadline.Before(t.hardDeadline)
+	t.hardDeadline = deadline
+	if t.permErr != nil {
+		return
+	}
+	if t.timer != nil && t.forward == nil && t.busy() == 0 && !t.awaitingStart &&
+		(wasClamped || !deadline.IsZero() && t.deadline.After(deadline)) {
+		// As Reconfigure does.
+		t.timer.Stop()
+		start := t.countdownStart(t.since)
+		t.deadline = start.Add(t.clampPatience(start, t.nextPatience()))
+		t.timer.Reset(t.deadline.Sub(t.now()))
+		t.syncConnDeadlines()
+	}
+	t.armHardTimer()
+}
+
+// armHardTimer starts the timer for the hard deadline, if there is one.
+// Must be called with the write lock held.
+func (t *IdleTracker) armHardTimer() {
+	if t.hardDeadline.IsZero() {
+		return
+	}
+	t.hardTimer = time.AfterFunc(t.hardDeadline.Sub(t.now()), func() {
+		t.fireIdling(ErrHardDeadline)
+	})
+}
+
+// Fire ends the tracker right away with reason as Err, or with
+// context.DeadlineExceeded if nil, as if its patience had run out,
+// regardless of connections and skipping anything set by SetPreShutdown.
+// It has no effect on a tracker that is done already.
+// IdleOnlyContext ends as well.
+//
+// Use this to test code that waits for Done, see netutiltest.ForceFire.
+func (t *IdleTracker) Fire(reason error) {
+	if reason == nil {
+		reason = context.DeadlineExceeded
+	}
+	t.fireIdling(reason)
+}
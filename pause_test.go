@@ -0,0 +1,140 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestPauseResume(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond)
+	i.Pause()
+	i.Pause()
+	if _, ok := i.Deadline(); ok {
+		t.Error("A paused tracker should not have a deadline")
+	}
+	select {
+	case <-i.Done():
+		t.Fatal("The tracker fired while paused")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	i.Resume()
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("The tracker has not fired after Resume")
+	}
+	if err := i.Err(); err != context.DeadlineExceeded {
+		t.Errorf("Err = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestHardDeadlineWhilePaused(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond)
+	i.Pause()
+	hard := time.Now().Add(100 * time.Millisecond)
+	i.SetHardDeadline(hard)
+
+	select {
+	case <-i.Done():
+		if time.Now().Before(hard) {
+			t.Error("The tracker fired before its hard deadline")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The hard deadline has not been enforced while paused")
+	}
+	if err := i.Err(); err != netutil.ErrHardDeadline {
+		t.Errorf("Err = %v, want ErrHardDeadline", err)
+	}
+}
+
+func TestHardDeadlineRemoved(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	i.SetHardDeadline(time.Now().Add(20 * time.Millisecond))
+	i.SetHardDeadline(time.Time{})
+	select {
+	case <-i.Done():
+		t.Fatal("A removed hard deadline has fired")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWithMaxDeadlineClampsPatience(t *testing.T) {
+	clock := newFakeClock()
+	hard := clock.Now().Add(30 * time.Minute)
+	var clamped int
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+		netutil.WithClock(clock.Now), netutil.WithMaxDeadline(hard),
+		netutil.WithEventHook(func(ev netutil.Event) {
+			if ev.Kind == netutil.EventDeadlineClamped {
+				clamped++
+			}
+		}))
+	defer i.SetHardDeadline(time.Time{})
+
+	if d, ok := i.Deadline(); !ok || !d.Equal(hard) {
+		t.Errorf("Deadline = %v, %v; want the hard deadline %v", d, ok, hard)
+	}
+
+	conn := &netutiltest.Conn{}
+	clock.Advance(10 * time.Minute)
+	i.ConnState(conn, http.StateActive)
+	i.ConnState(conn, http.StateClosed)
+	if d, _ := i.Deadline(); !d.Equal(hard) {
+		t.Errorf("Deadline = %v after activity, want it clamped to %v", d, hard)
+	}
+	if clamped != 2 {
+		t.Errorf("Got %d EventDeadlineClamped, want 2", clamped)
+	}
+
+	// A patience that ends before the ceiling is left alone.
+	if err := i.Reconfigure(netutil.WithPatience(5 * time.Minute)); err != nil {
+		t.Fatalf("Reconfigure: %v", err)
+	}
+	if d, _ := i.Deadline(); !d.Equal(clock.Now().Add(5 * time.Minute)) {
+		t.Errorf("Deadline = %v, want it unclamped", d)
+	}
+}
+
+func TestWithMaxDeadlineFires(t *testing.T) {
+	for _, busy := range []bool{false, true} {
+		i := netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+			netutil.WithMaxDeadline(time.Now().Add(30*time.Millisecond)))
+		if busy {
+			i.ConnState(&netutiltest.Conn{}, http.StateActive)
+		}
+		select {
+		case <-i.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatalf("busy=%v: The tracker has not fired at its ceiling", busy)
+		}
+		if err := i.Err(); err != netutil.ErrHardDeadline {
+			t.Errorf("busy=%v: Err = %v, want ErrHardDeadline", busy, err)
+		}
+	}
+}
+
+func TestSetHardDeadlineClampsWhileIdle(t *testing.T) {
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithClock(clock.Now))
+	hard := clock.Now().Add(10 * time.Minute)
+	i.SetHardDeadline(hard)
+	defer i.SetHardDeadline(time.Time{})
+	if d, _ := i.Deadline(); !d.Equal(hard) {
+		t.Errorf("Deadline = %v, want it clamped to %v", d, hard)
+	}
+}
+
+func TestWithMaxDeadlineZero(t *testing.T) {
+	if _, err := netutil.NewIdleTrackerErr(context.Background(), time.Minute,
+		netutil.WithMaxDeadline(time.Time{})); err == nil {
+		t.Error("WithMaxDeadline with a zero time should have been rejected")
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+var (
+	// ErrNotSocket is returned for a file descriptor that should have been
+	// that of a socket, but is not, such as a FIFO.
+	ErrNotSocket = errors.New("netutil: file descriptor is not a socket")
+
+	// ErrNotPipe is returned by AcceptedPipe for anything but a pipe or FIFO.
+	ErrNotPipe = errors.New("netutil: file descriptor is not a pipe")
+)
+
+// AcceptedPipe is for pipes or FIFOs passed on activation what
+// AcceptedConnection is for sockets, such as with systemd's ListenFIFO.
+// The file descriptor gets duplicated, hence the caller retains ownership.
+//
+// On Windows this returns ErrNotSupported.
+func AcceptedPipe(f *os.File) (io.ReadWriteCloser, error) {
+	dup, err := dupFile(f)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := dup.Stat()
+	if err != nil {
+		dup.Close()
+		return nil, err
+	}
+	if fi.Mode()&os.ModeNamedPipe == 0 {
+		dup.Close()
+		return nil, &os.PathError{Op: "open", Path: f.Name(), Err: ErrNotPipe}
+	}
+	return dup, nil
+}
@@ -0,0 +1,61 @@
+// This file is released into the public domain.
+
+//go:build !windows
+// +build !windows
+
+package netutil_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func TestPipeIsNoSocket(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	if _, err := netutil.AcceptedConnection(r); !errors.Is(err, netutil.ErrNotSocket) {
+		t.Errorf("AcceptedConnection should have returned ErrNotSocket, got: %v", err)
+	}
+	if _, err := netutil.ActivatedListeners(context.Background(), 1*time.Minute, []*os.File{r}); !errors.Is(err, netutil.ErrNotSocket) {
+		t.Errorf("ActivatedListeners should have returned ErrNotSocket, got: %v", err)
+	}
+}
+
+func TestAcceptedPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	defer w.Close()
+
+	p, err := netutil.AcceptedPipe(r)
+	if err != nil {
+		t.Fatalf("AcceptedPipe: %v", err)
+	}
+	r.Close() // The caller retains ownership of the original.
+	defer p.Close()
+
+	go w.Write([]byte("event"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(p, buf); err != nil || string(buf) != "event" {
+		t.Errorf("Failed to read from the pipe: %q, %v", buf, err)
+	}
+
+	f, client := acceptedFile(t)
+	defer client.Close()
+	defer f.Close()
+	if _, err := netutil.AcceptedPipe(f); !errors.Is(err, netutil.ErrNotPipe) {
+		t.Errorf("AcceptedPipe should have returned ErrNotPipe for a socket, got: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"net"
+	"net/http"
+)
+
+// Ready is false once the tracker has entered the window before its deadline
+// set by WithWarnBefore, and after it is done. The deadline is that reported
+// by Deadline, or any hard deadline that comes first, see SetHardDeadline,
+// so a parent's deadline counts even while busy. Any activity that postpones
+// the deadline makes it ready again, unless it is done.
+func (t *IdleTracker) Ready() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.permErr != nil {
+		return false
+	}
+	deadline, ok := t.deadlineLocked()
+	if !t.hardDeadline.IsZero() && (!ok || t.hardDeadline.Before(deadline)) {
+		deadline, ok = t.hardDeadline, true
+	}
+	return !ok || t.now().Before(deadline.Add(-t.warnBefore))
+}
+
+// ReadyHandler returns a handler for health checks, such as of a load balancer,
+// that responds with status 503 "Service Unavailable" unless the tracker is Ready.
+//
+// Don't wrap it in Handler, else health checks would keep the service alive.
+func (t *IdleTracker) ReadyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if !t.Ready() {
+			http.Error(w, "idling", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GatedListener returns a net.Listener that turns away new connections,
+// closing them right away, while the tracker is not Ready, such as within
+// the window set by WithWarnBefore. Else a trickle of connections could keep
+// postponing the deadline forever. Connections accepted before are left alone.
+//
+// It doesn't report connections to the tracker on its own;
+// use ConnState or WrapListener for that, as usual.
+func (t *IdleTracker) GatedListener(ln net.Listener) net.Listener {
+	return &gatedListener{Listener: ln, tracker: t}
+}
+
+// gatedListener implements net.Listener.
+type gatedListener struct {
+	net.Listener
+	tracker *IdleTracker
+}
+
+// Accept implements net.Listener.
+func (l *gatedListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil || l.tracker.Ready() {
+			return c, err
+		}
+		c.Close()
+	}
+}
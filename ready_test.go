@@ -0,0 +1,132 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestReady(t *testing.T) {
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+		netutil.WithClock(clock.Now), netutil.WithWarnBefore(10*time.Minute))
+	h := i.ReadyHandler()
+	status := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+		return rec.Code
+	}
+
+	clock.Advance(49 * time.Minute)
+	if !i.Ready() || status() != http.StatusNoContent {
+		t.Error("Ahead of the warning window the tracker should be ready")
+	}
+	clock.Advance(1 * time.Minute)
+	if i.Ready() || status() != http.StatusServiceUnavailable {
+		t.Error("In the warning window the tracker should not be ready")
+	}
+
+	conn := &netutiltest.Conn{}
+	i.ConnState(conn, http.StateActive)
+	if !i.Ready() {
+		t.Error("While busy the tracker should be ready")
+	}
+	i.ConnState(conn, http.StateClosed)
+	if !i.Ready() {
+		t.Error("After activity postponed the deadline, the tracker should be ready")
+	}
+}
+
+func TestReadyAfterDone(t *testing.T) {
+	parentCtx, cancelParent := context.WithCancel(context.Background())
+	i := netutil.NewIdleTracker(parentCtx, 1*time.Hour)
+	if !i.Ready() {
+		t.Error("Without WithWarnBefore a fresh tracker should be ready")
+	}
+	cancelParent()
+	<-i.Done()
+	if i.Ready() {
+		t.Error("Once done the tracker must not be ready")
+	}
+}
+
+func TestReadyBeforeParentOrHardDeadline(t *testing.T) {
+	parent, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	i := netutil.NewIdleTracker(parent, 1*time.Hour, netutil.WithWarnBefore(10*time.Minute))
+	if i.Ready() {
+		t.Error("Within the warning window of its parent's deadline the tracker should not be ready")
+	}
+	i.ConnState(&netutiltest.Conn{}, http.StateActive)
+	if i.Ready() {
+		t.Error("While busy, within the warning window of its parent's deadline the tracker should not be ready")
+	}
+
+	j := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithWarnBefore(10*time.Minute))
+	j.ConnState(&netutiltest.Conn{}, http.StateActive)
+	j.SetHardDeadline(time.Now().Add(1 * time.Minute))
+	if j.Ready() {
+		t.Error("While busy, within the warning window of the hard deadline the tracker should not be ready")
+	}
+}
+
+func TestGatedListener(t *testing.T) {
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+		netutil.WithClock(clock.Now), netutil.WithWarnBefore(10*time.Minute))
+	inner, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ln := i.GatedListener(inner)
+	defer ln.Close()
+
+	early, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer early.Close()
+	inFlight, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept outside of the warning window: %v", err)
+	}
+	defer inFlight.Close()
+
+	clock.Advance(55 * time.Minute)
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+	late, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer late.Close()
+	late.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if b, err := ioutil.ReadAll(late); err != nil || len(b) != 0 {
+		t.Errorf("A connection within the warning window should have been turned away, got: %q, %v", b, err)
+	}
+
+	go early.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	inFlight.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(inFlight, buf); err != nil {
+		t.Errorf("The connection accepted before should continue, got: %v", err)
+	}
+
+	ln.Close()
+	if c := <-accepted; c != nil {
+		t.Error("Accept returned a connection within the warning window")
+	}
+}
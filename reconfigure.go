@@ -0,0 +1,79 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"time"
+)
+
+// Reconfigure applies opts to the running tracker, such as on SIGHUP.
+// If idle, the deadline gets recalculated from the start of the idle period,
+// which can have the tracker fire right away.
+//
+// Options that only apply on construction, such as WithClock or WithConnKey,
+// result in an error wrapping ErrNotReconfigurable. On any error none of opts
+// takes effect. Those that can be changed are:
+// WithPatience, WithCumulativeIdle, WithWarnBefore, WithAdaptivePatience,
+// WithFirePolicy, WithShutdownBudget, WithShutdownWriteTimeout,
+// and WithLastRequestGrace.
+func (t *IdleTracker) Reconfigure(opts ...Option) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	type tunables struct {
+		patience       time.Duration
+		cumulativeIdle time.Duration
+		warnBefore     time.Duration
+		adaptive       *adaptivePatience
+		shutdownBudget time.Duration
+		shutdownSteps  int
+		writeTimeout   time.Duration
+		lastGrace      time.Duration
+		firePolicy     FirePolicy
+	}
+	was := tunables{t.patience, t.cumulativeIdle, t.warnBefore, t.adaptive,
+		t.shutdownBudget, t.shutdownSteps, t.shutdownWriteTimeout, t.lastRequestGrace,
+		t.firePolicy}
+	for _, opt := range opts {
+		if err := opt(t); err != nil {
+			t.patience, t.cumulativeIdle, t.warnBefore, t.adaptive =
+				was.patience, was.cumulativeIdle, was.warnBefore, was.adaptive
+			t.shutdownBudget, t.shutdownSteps = was.shutdownBudget, was.shutdownSteps
+			t.shutdownWriteTimeout, t.lastRequestGrace = was.writeTimeout, was.lastGrace
+			t.firePolicy = was.firePolicy
+			return err
+		}
+	}
+
+	t.rearm()
+	return nil
+}
+
+// SwapPatience sets the patience to d and returns the previous one,
+// re-arming the timer if the tracker is idle, like Reconfigure with
+// WithPatience does. A d of zero or less only returns the patience.
+func (t *IdleTracker) SwapPatience(d time.Duration) (old time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	old = t.patience
+	if d > 0 {
+		t.patience = d
+		t.rearm()
+	}
+	return old
+}
+
+// rearm sets the deadline anew from the start of idling, if idle.
+// Must be called with the write lock held.
+func (t *IdleTracker) rearm() {
+	if t.permErr != nil || t.forward != nil || t.busy() > 0 || t.awaitingStart {
+		return
+	}
+	t.timer.Stop()
+	start := t.countdownStart(t.since)
+	t.deadline = start.Add(t.clampPatience(start, t.nextPatience()))
+	t.timer.Reset(t.deadline.Sub(t.now()))
+	t.syncConnDeadlines()
+}
@@ -0,0 +1,178 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestReconfigureWhileIdle(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithClock(clock.Now))
+
+	clock.Advance(10 * time.Minute)
+	if err := i.Reconfigure(netutil.WithPatience(30 * time.Minute)); err != nil {
+		t.Fatalf("Reconfigure returned an error: %v", err)
+	}
+	if d, _ := i.Deadline(); !d.Equal(start.Add(30 * time.Minute)) {
+		t.Errorf("The deadline should count from the start of the idle period, got: %v", d)
+	}
+
+	// Ten minutes have passed already.
+	if err := i.Reconfigure(netutil.WithPatience(5 * time.Minute)); err != nil {
+		t.Fatalf("Reconfigure returned an error: %v", err)
+	}
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Error("Having been idle longer than the new patience, the tracker should have fired")
+	}
+}
+
+func TestReconfigureWhileActive(t *testing.T) {
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithClock(clock.Now))
+
+	c := &netutiltest.Conn{}
+	i.ConnState(c, http.StateActive)
+	if err := i.Reconfigure(netutil.WithPatience(2 * time.Minute)); err != nil {
+		t.Fatalf("Reconfigure returned an error: %v", err)
+	}
+	if _, ok := i.Deadline(); ok {
+		t.Error("Reconfigure must not arm the timer while connections are active")
+	}
+	clock.Advance(5 * time.Minute)
+	i.ConnState(c, http.StateClosed)
+	if d, _ := i.Deadline(); !d.Equal(clock.Now().Add(2 * time.Minute)) {
+		t.Errorf("The new patience has not been used after the last connection, deadline: %v", d)
+	}
+}
+
+func TestReconfigureFixedOptions(t *testing.T) {
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithClock(clock.Now))
+	want, _ := i.Deadline()
+
+	for _, opt := range []netutil.Option{
+		netutil.WithClock(time.Now),
+		netutil.WithConnKey(netutil.ConnAddrKey),
+		netutil.WithIgnoreLoopback(),
+	} {
+		err := i.Reconfigure(netutil.WithPatience(1*time.Minute), opt)
+		if !errors.Is(err, netutil.ErrNotReconfigurable) {
+			t.Errorf("Expected ErrNotReconfigurable, got: %v", err)
+		}
+	}
+	if d, _ := i.Deadline(); !d.Equal(want) {
+		t.Errorf("A failed Reconfigure must not apply any option, deadline: %v", d)
+	}
+	if err := i.Reconfigure(netutil.WithPatience(0)); err != netutil.ErrInvalidPatience {
+		t.Errorf("Expected ErrInvalidPatience, got: %v", err)
+	}
+}
+
+func TestReconfigureRollsBackFirePolicy(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond)
+	err := i.Reconfigure(netutil.WithFirePolicy(netutil.FireWait), netutil.WithClock(time.Now))
+	if !errors.Is(err, netutil.ErrNotReconfigurable) {
+		t.Fatalf("Expected ErrNotReconfigurable, got: %v", err)
+	}
+	i.SetPreShutdown(func(context.Context) error {
+		i.ConnState(&netutiltest.Conn{}, http.StateActive) // Would keep FireWait waiting.
+		return nil
+	})
+
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("FireWait has been left in effect by a failed Reconfigure")
+	}
+}
+
+func TestReconfigureConcurrently(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+
+	var wg sync.WaitGroup
+	for n := 0; n < 4; n++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for k := 0; k < 100; k++ {
+				c := &netutiltest.Conn{}
+				i.ConnState(c, http.StateNew)
+				i.ConnState(c, http.StateClosed)
+			}
+		}()
+		go func(n int) {
+			defer wg.Done()
+			for k := 0; k < 100; k++ {
+				_ = i.Reconfigure(netutil.WithPatience(time.Duration(n+1) * time.Hour))
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	if n := i.ActiveConns(); n != 0 {
+		t.Errorf("No connection should be left, got: %d", n)
+	}
+	if _, ok := i.Deadline(); !ok {
+		t.Error("The tracker should be idle with a deadline")
+	}
+}
+
+func TestSwapPatience(t *testing.T) {
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Minute, netutil.WithClock(clock.Now))
+
+	const swappers, swaps = 4, 100
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		seen = map[time.Duration]int{}
+	)
+	for k := 0; k < swappers; k++ {
+		wg.Add(2)
+		go func(k int) {
+			defer wg.Done()
+			for n := 1; n <= swaps; n++ {
+				old := i.SwapPatience(time.Duration(k*swaps+n) * time.Hour)
+				mu.Lock()
+				seen[old]++
+				mu.Unlock()
+			}
+		}(k)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < swaps; n++ {
+				c := &netutiltest.Conn{}
+				i.ConnState(c, http.StateNew)
+				i.ConnState(c, http.StateClosed)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every patience set but the last one has been returned exactly once.
+	last := i.SwapPatience(0)
+	seen[last]++
+	if seen[1*time.Minute] != 1 {
+		t.Errorf("The initial patience has been returned %d times", seen[1*time.Minute])
+	}
+	for p := 1; p <= swappers*swaps; p++ {
+		if n := seen[time.Duration(p)*time.Hour]; n != 1 {
+			t.Fatalf("Patience %dh has been returned %d times", p, n)
+		}
+	}
+	if d, _ := i.Deadline(); !d.Equal(clock.Now().Add(last)) {
+		t.Errorf("Deadline = %v, want one using the most recent patience: %v", d, clock.Now().Add(last))
+	}
+}
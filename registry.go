@@ -0,0 +1,73 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"errors"
+	"sync"
+)
+
+// registry holds the trackers created WithRegister until they are done.
+var registry struct {
+	sync.Mutex
+	trackers []*IdleTracker
+}
+
+// WithRegister lists the tracker under name in RegisteredTrackers until
+// it is done, for debugging, such as to dump all trackers of a process.
+func WithRegister(name string) Option {
+	return func(t *IdleTracker) error {
+		if err := t.fixed("WithRegister"); err != nil {
+			return err
+		}
+		if name == "" {
+			return errors.New("netutil: WithRegister needs a non-empty name")
+		}
+		t.name = name
+		return nil
+	}
+}
+
+// RegisteredTrackers returns the trackers created WithRegister that are
+// not done yet, in the order they have been created.
+func RegisteredTrackers() []*IdleTracker {
+	registry.Lock()
+	defer registry.Unlock()
+	return append([]*IdleTracker(nil), registry.trackers...)
+}
+
+// Name returns the name given by WithRegister, or an empty string.
+func (t *IdleTracker) Name() string {
+	return t.name
+}
+
+// register lists t if it has got a name, as the last step of its
+// construction, unless it is done already, which its timers can have
+// caused by then.
+func register(t *IdleTracker) {
+	if t.name == "" {
+		return
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	select {
+	case <-t.done: // And unregister has been called, or waits for the lock.
+	default:
+		registry.trackers = append(registry.trackers, t)
+	}
+}
+
+func unregister(t *IdleTracker) {
+	registry.Lock()
+	defer registry.Unlock()
+	for k, other := range registry.trackers {
+		if other == t {
+			copy(registry.trackers[k:], registry.trackers[k+1:])
+			registry.trackers[len(registry.trackers)-1] = nil
+			registry.trackers = registry.trackers[:len(registry.trackers)-1]
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func registeredNames() []string {
+	var names []string
+	for _, t := range netutil.RegisteredTrackers() {
+		names = append(names, t.Name())
+	}
+	return names
+}
+
+func TestWithRegister(t *testing.T) {
+	a := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithRegister("a"))
+	b := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithRegister("b"))
+	defer b.Fire(nil)
+	netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	if got := registeredNames(); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("RegisteredTrackers = %v, want [a b]", got)
+	}
+
+	a.Fire(nil)
+	if got := registeredNames(); len(got) != 1 || got[0] != "b" {
+		t.Errorf("After firing, RegisteredTrackers = %v, want [b]", got)
+	}
+
+	// Done from the start.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	netutil.NewIdleTracker(ctx, 1*time.Hour, netutil.WithRegister("c"))
+	if got := registeredNames(); len(got) != 1 || got[0] != "b" {
+		t.Errorf("A tracker done from the start is listed, got: %v", got)
+	}
+
+	// Fired before construction has finished.
+	netutil.NewIdleTracker(context.Background(), 1*time.Nanosecond, netutil.WithRegister("d"))
+	<-time.After(10 * time.Millisecond)
+	if got := registeredNames(); len(got) != 1 || got[0] != "b" {
+		t.Errorf("A tracker that fired right away is listed, got: %v", got)
+	}
+
+	if _, err := netutil.NewIdleTrackerErr(context.Background(), 1*time.Hour, netutil.WithRegister("")); err == nil {
+		t.Error("An empty name has been accepted")
+	}
+}
+
+func TestWithRegisterTransfer(t *testing.T) {
+	src := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithRegister("src"))
+	dst := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithRegister("dst"))
+	defer dst.Fire(nil)
+
+	src.TransferTo(dst)
+	if got := registeredNames(); len(got) != 1 || got[0] != "dst" {
+		t.Errorf("After TransferTo, RegisteredTrackers = %v, want [dst]", got)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// AcceptLoop accepts connections and hands each to handle in a goroutine
+// of its own, like http.Server.Serve does, but for any protocol.
+//
+// Temporary errors, such as running out of file descriptors, are met by
+// backing off for up to a second. Any other error ends the loop and gets
+// returned. Cancelling ctx ends it with ctx.Err().
+//
+// Listeners with a method SetDeadline, such as *net.TCPListener, get their
+// Accept interrupted by a deadline on cancellation and are left open, so
+// the caller decides when to close the file descriptor. Others get closed.
+func AcceptLoop(ctx context.Context, ln net.Listener, handle func(net.Conn)) error {
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(stop)
+		<-stopped
+		if dl, ok := ln.(deadliner); ok {
+			dl.SetDeadline(time.Time{})
+		}
+	}()
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			interruptAccept(ln)
+		case <-stop:
+		}
+	}()
+
+	var tempDelay time.Duration
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := 1 * time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				select {
+				case <-time.After(tempDelay):
+					continue
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return err
+		}
+		tempDelay = 0
+		go handle(c)
+	}
+}
+
+// deadliner is implemented by listeners whose Accept can be interrupted.
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// interruptAccept has any Accept on ln return, which closes ln
+// unless it supports deadlines.
+func interruptAccept(ln net.Listener) {
+	if dl, ok := ln.(deadliner); ok && dl.SetDeadline(time.Unix(1, 0)) == nil {
+		return
+	}
+	ln.Close()
+}
+
+// ErrListenerFailed is what the tracker fires with, wrapping the cause,
+// once the listener served by ServeRaw fails for good.
+// Use errors.Is to check for it, and errors.Unwrap to get the cause.
+var ErrListenerFailed = errors.New("netutil: listener failed")
+
+// listenerError is ErrListenerFailed with its cause.
+type listenerError struct {
+	err error
+}
+
+func (e *listenerError) Error() string { return ErrListenerFailed.Error() + ": " + e.err.Error() }
+
+func (e *listenerError) Is(target error) bool { return target == ErrListenerFailed }
+
+func (e *listenerError) Unwrap() error { return e.err }
+
+// ServeRaw runs AcceptLoop with the tracker reporting each connection
+// as active until handle returns, after which the connection gets closed.
+// That gives servers of any protocol the idle shutdown http.Server has.
+//
+// It returns once the tracker is done, with the latter's Err, or once ctx is,
+// with ctx.Err(). The context passed to handle is done by then, too.
+// See AcceptLoop for whether ln gets closed.
+// Should ln fail for good, the tracker fires with ErrListenerFailed
+// wrapping the error of Accept, and that gets returned.
+// A panic in handle gets logged and ends only the connection, not the loop.
+func (t *IdleTracker) ServeRaw(ctx context.Context, ln net.Listener, handle func(context.Context, net.Conn)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-t.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := AcceptLoop(ctx, ln, func(c net.Conn) {
+		t.ConnState(c, http.StateNew)
+		t.ConnState(c, http.StateActive)
+		defer func() {
+			if r := recover(); r != nil {
+				const size = 64 << 10
+				buf := make([]byte, size)
+				buf = buf[:runtime.Stack(buf, false)]
+				log.Printf("netutil: panic serving %v: %v\n%s", c.RemoteAddr(), r, buf)
+			}
+			c.Close()
+			t.ConnState(c, http.StateClosed)
+		}()
+		handle(ctx, c)
+	})
+	if err != nil && ctx.Err() == nil {
+		t.fire(&listenerError{err: err})
+	}
+	if tErr := t.Err(); tErr != nil {
+		return tErr
+	}
+	return err
+}
+
+// ServeResult sums up a run of ServeUntilIdle.
+type ServeResult struct {
+	Ran   time.Duration // From the call of ServeUntilIdle until it returned.
+	Conns int           // Connections accepted.
+	Drain time.Duration // How long server.Shutdown took.
+
+	// Reason is the tracker's Err, such as context.DeadlineExceeded for
+	// having idled out. It's nil if the server ended on its own.
+	Reason error
+	Stats  Stats
+}
+
+// ServeUntilIdle has server serve on ln until the tracker is done, then
+// shuts it down gracefully, allowing for up to drain for that,
+// or as set by WithShutdownBudget.
+// It returns with a summary of the run, for example to be logged on exit.
+//
+// The tracker gets hooked into server.ConnState, in front of any func there.
+// The returned error is that of Serve if it ended on its own, else that of Shutdown.
+//
+// Use a server only once with this, as it cannot serve again after Shutdown
+// anyway. The hook stays in place, for connections could still be around,
+// but once this has returned it only calls the func that was there before.
+func (t *IdleTracker) ServeUntilIdle(server *http.Server, ln net.Listener, drain time.Duration) (ServeResult, error) {
+	start := time.Now()
+	var (
+		mu    sync.Mutex
+		conns int
+		ended bool
+	)
+	next := server.ConnState
+	server.ConnState = func(c net.Conn, state http.ConnState) {
+		mu.Lock()
+		report := !ended
+		if report && state == http.StateNew {
+			conns++
+		}
+		mu.Unlock()
+		if report {
+			t.ConnState(c, state)
+		}
+		if next != nil {
+			next(c, state)
+		}
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ln)
+	}()
+
+	var (
+		res ServeResult
+		err error
+	)
+	select {
+	case err = <-served:
+	case <-t.Done():
+		res.Reason = t.Err()
+		drainStart := time.Now()
+		err = t.shutdownServer(server, drain)
+		res.Drain = time.Since(drainStart)
+		<-served // http.ErrServerClosed
+	}
+
+	mu.Lock()
+	res.Conns = conns
+	ended = true
+	mu.Unlock()
+	res.Stats = t.Stats()
+	res.Ran = time.Since(start)
+	return res, err
+}
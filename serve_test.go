@@ -0,0 +1,416 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+// temporaryError is what running out of file descriptors looks like.
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// scriptedListener returns the given errors or connections, in order,
+// and then blocks until closed.
+type scriptedListener struct {
+	mu      sync.Mutex
+	script  []interface{}
+	accepts int
+	closed  chan struct{}
+}
+
+func newScriptedListener(script ...interface{}) *scriptedListener {
+	return &scriptedListener{script: script, closed: make(chan struct{})}
+}
+
+func (l *scriptedListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	l.accepts++
+	if len(l.script) == 0 {
+		l.mu.Unlock()
+		<-l.closed
+		return nil, errors.New("use of closed listener")
+	}
+	next := l.script[0]
+	l.script = l.script[1:]
+	l.mu.Unlock()
+	if err, ok := next.(error); ok {
+		return nil, err
+	}
+	return next.(net.Conn), nil
+}
+
+func (l *scriptedListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+	return nil
+}
+
+func (l *scriptedListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+func TestAcceptLoopBackoff(t *testing.T) {
+	conn := &netutiltest.Conn{}
+	ln := newScriptedListener(temporaryError{}, temporaryError{}, temporaryError{}, conn)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handled := make(chan net.Conn, 1)
+	returned := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		returned <- netutil.AcceptLoop(ctx, ln, func(c net.Conn) { handled <- c })
+	}()
+
+	select {
+	case c := <-handled:
+		if c != conn {
+			t.Errorf("A different connection has been handled: %v", c)
+		}
+	case err := <-returned:
+		t.Fatalf("AcceptLoop gave up on temporary errors: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("AcceptLoop did not get past temporary errors")
+	}
+	// 5ms, 10ms, then 20ms.
+	if took := time.Since(start); took < 35*time.Millisecond {
+		t.Errorf("AcceptLoop did not back off on temporary errors, took: %v", took)
+	}
+
+	cancel()
+	select {
+	case err := <-returned:
+		if err != context.Canceled {
+			t.Errorf("AcceptLoop should have returned context.Canceled, got: %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("AcceptLoop did not return after its context has been cancelled")
+	}
+	select {
+	case <-ln.closed:
+	default:
+		t.Error("The listener has not been closed")
+	}
+}
+
+func TestAcceptLoopPermanentError(t *testing.T) {
+	permanent := errors.New("permanent")
+	ln := newScriptedListener(permanent)
+	err := netutil.AcceptLoop(context.Background(), ln, func(c net.Conn) {
+		t.Errorf("Nothing should have been handled, got: %v", c)
+	})
+	if err != permanent {
+		t.Errorf("AcceptLoop should have returned the permanent error, got: %v", err)
+	}
+}
+
+func TestServeRawEcho(t *testing.T) {
+	log.SetOutput(ioutil.Discard) // The panic below gets logged.
+	defer log.SetOutput(os.Stderr)
+
+	i := netutil.NewIdleTracker(context.Background(), 100*time.Millisecond)
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- i.ServeRaw(context.Background(), ln, func(_ context.Context, c net.Conn) {
+			buf := make([]byte, 4)
+			for {
+				if _, err := io.ReadFull(c, buf); err != nil {
+					return
+				}
+				if bytes.Equal(buf, []byte("boom")) {
+					panic("boom")
+				}
+				c.Write(buf)
+			}
+		})
+	}()
+
+	echo := func(msg string) (string, error) {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(2 * time.Second))
+		c.Write([]byte(msg))
+		buf, err := ioutil.ReadAll(c)
+		return string(buf), err
+	}
+	if got, err := echo("boom"); got != "" || err != nil {
+		t.Errorf("The panicking handler should have closed the connection, got: %q, %v", got, err)
+	}
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond) // Longer than the patience.
+	c.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("The loop should still serve after a panic, got: %q, %v", buf, err)
+	}
+	if isClosed(i.Done()) {
+		t.Error("The tracker fired while a connection was being handled")
+	}
+	c.Close()
+
+	select {
+	case err := <-served:
+		if err != context.DeadlineExceeded {
+			t.Errorf("ServeRaw should have returned the tracker's error, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("ServeRaw has not returned after idling out")
+	}
+}
+
+func TestServeUntilIdle(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 100*time.Millisecond)
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "hello")
+	})}
+	type outcome struct {
+		res netutil.ServeResult
+		err error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		res, err := i.ServeUntilIdle(server, ln, 1*time.Second)
+		done <- outcome{res, err}
+	}()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	for n := 0; n < 2; n++ {
+		resp, err := client.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	select {
+	case o := <-done:
+		if o.err != nil {
+			t.Errorf("ServeUntilIdle returned an error: %v", o.err)
+		}
+		r := o.res
+		if r.Conns != 2 {
+			t.Errorf("Conns = %d, want 2", r.Conns)
+		}
+		if r.Reason != context.DeadlineExceeded {
+			t.Errorf("Reason = %v, want context.DeadlineExceeded", r.Reason)
+		}
+		if r.Ran < 100*time.Millisecond || r.Drain < 0 || r.Drain > r.Ran {
+			t.Errorf("Implausible timings, ran: %v, drain: %v", r.Ran, r.Drain)
+		}
+		if r.Stats.IdleDuration < 100*time.Millisecond || r.Stats.ActiveDuration <= 0 {
+			t.Errorf("Implausible stats: %+v", r.Stats)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("ServeUntilIdle has not returned after idling out")
+	}
+}
+
+func TestServeUntilIdleUnhooks(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	var passedOn int
+	server := &http.Server{ConnState: func(net.Conn, http.ConnState) { passedOn++ }}
+	ln.Close() // Serve ends on its own.
+	if _, err := i.ServeUntilIdle(server, ln, 1*time.Second); err == nil {
+		t.Fatal("ServeUntilIdle on a closed listener returned no error")
+	}
+
+	server.ConnState(&netutiltest.Conn{}, http.StateActive)
+	if n := i.ActiveConns(); n != 0 {
+		t.Errorf("After ServeUntilIdle has returned, the hook still reports to the tracker, ActiveConns = %d", n)
+	}
+	if passedOn != 1 {
+		t.Errorf("The func hooked before has been called %d times, want 1", passedOn)
+	}
+}
+
+func TestShutdownWriteTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	i := netutil.NewIdleTracker(ctx, 1*time.Hour,
+		netutil.WithShutdownWriteTimeout(100*time.Millisecond))
+
+	writing := make(chan struct{})
+	chunk := make([]byte, 64<<10)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(writing)
+		for { // Until the client's buffers are full, and then some.
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+	})}
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		_, err := i.ServeUntilIdle(server, ln, 10*time.Second)
+		served <- err
+	}()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer client.Close()
+	io.WriteString(client, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n") // Never reads.
+	<-writing
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("ServeUntilIdle = %v", err)
+		}
+		if took := time.Since(start); took > 2*time.Second {
+			t.Errorf("Shutdown took %v", took)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The stuck write has stalled the shutdown")
+	}
+}
+
+func TestServeRawListenerFailed(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	cause := errors.New("network is down")
+	ln := newScriptedListener(&netutiltest.Conn{}, cause)
+
+	err := i.ServeRaw(context.Background(), ln, func(context.Context, net.Conn) {})
+	if !errors.Is(err, netutil.ErrListenerFailed) || !errors.Is(err, cause) {
+		t.Errorf("ServeRaw = %v, want ErrListenerFailed wrapping: %v", err, cause)
+	}
+	if !isClosed(i.Done()) {
+		t.Fatal("The tracker did not fire on the failed listener")
+	}
+	if err := i.Err(); errors.Unwrap(err) != cause || !errors.Is(err, netutil.ErrListenerFailed) {
+		t.Errorf("Err = %v, want ErrListenerFailed wrapping: %v", err, cause)
+	}
+}
+
+func TestServeRawReturnsPromptly(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 30*time.Millisecond)
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer ln.Close()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- i.ServeRaw(context.Background(), ln, func(context.Context, net.Conn) {})
+	}()
+	select {
+	case err := <-served:
+		if err != context.DeadlineExceeded {
+			t.Errorf("ServeRaw should have returned the tracker's error, got: %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("ServeRaw has not returned without any connection")
+	}
+
+	// The listener has been left open, and without a deadline.
+	go func() {
+		if c, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+			c.Close()
+		}
+	}()
+	if c, err := ln.Accept(); err != nil {
+		t.Errorf("The listener is no longer usable: %v", err)
+	} else {
+		c.Close()
+	}
+}
+
+func TestLastRequestGrace(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+		netutil.WithShutdownBudget(1*time.Millisecond, 1),
+		netutil.WithLastRequestGrace(300*time.Millisecond))
+
+	body := bytes.Repeat([]byte("0123456789abcdef"), 4<<10)
+	half := len(body) / 2
+	flushed := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body[:half])
+		w.(http.Flusher).Flush()
+		close(flushed)
+		time.Sleep(100 * time.Millisecond) // Fires in between.
+		w.Write(body[half:])
+	})}
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		_, err := i.ServeUntilIdle(server, ln, 10*time.Second)
+		served <- err
+	}()
+
+	got := make(chan []byte, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			got <- nil
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		got <- b
+	}()
+	<-flushed
+	i.Fire(nil)
+
+	if b := <-got; !bytes.Equal(b, body) {
+		t.Errorf("The response in flight got truncated to %d of %d bytes", len(b), len(body))
+	}
+	if err := <-served; err != nil {
+		t.Errorf("ServeUntilIdle = %v", err)
+	}
+}
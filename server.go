@@ -0,0 +1,51 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Server is a http.Server that shuts itself down gracefully once it has
+// been idle for Patience. Configure the embedded http.Server as usual;
+// any ConnState func there is called after the tracker's.
+type Server struct {
+	*http.Server
+	Patience time.Duration // Zero means 15 minutes, as with NewIdleTracker.
+}
+
+// ListenAndServe listens on the TCP address Addr, or ":http" if empty,
+// and then calls Serve.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve is like that of http.Server, but returns nil once the server
+// has idled out and been shut down, allowing up to 30 seconds for that.
+// Should the shutdown fail, its error gets returned instead.
+func (s *Server) Serve(ln net.Listener) error {
+	patience := s.Patience
+	if patience == 0 {
+		patience = 15 * time.Minute
+	}
+	t, err := NewIdleTrackerErr(context.Background(), patience)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	_, err = t.ServeUntilIdle(s.Server, ln, shutdownGrace)
+	return err
+}
@@ -0,0 +1,104 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func ExampleServer() {
+	// Serves until there has been no connection for 15 minutes.
+
+	server := netutil.Server{
+		Server:   &http.Server{Addr: "localhost:8080", Handler: http.NotFoundHandler()},
+		Patience: 15 * time.Minute,
+	}
+	if err := server.ListenAndServe(); err != nil {
+		log.Fatalf("server.ListenAndServe: %v", err)
+	}
+}
+
+func TestServerIdlesOut(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	var chained int32
+	server := netutil.Server{
+		Server: &http.Server{
+			Handler: http.NotFoundHandler(),
+			ConnState: func(net.Conn, http.ConnState) {
+				atomic.AddInt32(&chained, 1)
+			},
+		},
+		Patience: 50 * time.Millisecond,
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ln) }()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Get("http://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve should have returned nil after idling out, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("The server has not idled out")
+	}
+	if atomic.LoadInt32(&chained) == 0 {
+		t.Error("The server's own ConnState has not been called")
+	}
+}
+
+func TestServerInvalidPatience(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	server := netutil.Server{Server: &http.Server{}, Patience: -1 * time.Second}
+	if err := server.Serve(ln); err != netutil.ErrInvalidPatience {
+		t.Errorf("Serve with a negative patience returned %v, want ErrInvalidPatience", err)
+	}
+	if _, err := ln.Accept(); err == nil {
+		t.Error("The listener should have been closed")
+	}
+}
+
+func TestServerDefaultPatience(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	server := netutil.Server{Server: &http.Server{
+		Handler: http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}),
+	}}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(ln)
+	}()
+
+	resp, err := http.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Without a patience the server should still serve: %v", err)
+	}
+	resp.Body.Close()
+	server.Close()
+	if err := <-served; err != http.ErrServerClosed {
+		t.Errorf("Serve returned %v, want http.ErrServerClosed", err)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"context"
+	"time"
+)
+
+// preShutdownTimeout bounds the context passed to the func set by SetPreShutdown.
+const preShutdownTimeout = 30 * time.Second
+
+// SetPreShutdown sets a func to run once the patience has run out, but before
+// Done gets closed. Anyone waiting for Done, like the goroutine that shuts
+// down the server, will do so only after fn has returned. Use this for
+// cleanup that must happen first, such as flushing a write-ahead log.
+//
+// The context passed to fn expires after 30 seconds. Any error fn returns
+// doesn't keep the tracker from becoming done, but can be retrieved by
+// PreShutdownErr. Other causes of Done, such as the parent's, skip fn.
+//
+// Should the parent get cancelled while fn runs, the tracker is done right
+// away with the parent's error, and the context passed to fn gets cancelled.
+func (t *IdleTracker) SetPreShutdown(fn func(ctx context.Context) error) {
+	t.mu.Lock()
+	t.preShutdown = fn
+	t.mu.Unlock()
+}
+
+// PreShutdownErr returns the error of the func set by SetPreShutdown, if any.
+func (t *IdleTracker) PreShutdownErr() error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.preShutdownErr
+}
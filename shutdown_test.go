@@ -0,0 +1,270 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestPreShutdown(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond)
+	ranBeforeDone := make(chan bool, 1)
+	i.SetPreShutdown(func(ctx context.Context) error {
+		<-time.After(30 * time.Millisecond)
+		ranBeforeDone <- !isClosed(i.Done())
+		if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+			t.Error("The context passed to the pre-shutdown func is not bounded")
+		}
+		return nil
+	})
+
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("IdleTracker is not done")
+	}
+	select {
+	case before := <-ranBeforeDone:
+		if !before {
+			t.Error("Done was closed before the pre-shutdown func has returned")
+		}
+	default:
+		t.Error("Done was closed without the pre-shutdown func having run")
+	}
+	if err := i.PreShutdownErr(); err != nil {
+		t.Errorf("PreShutdownErr = %v, want nil", err)
+	}
+}
+
+func TestPreShutdownNotAfterFire(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond)
+	var ran int32
+	i.SetPreShutdown(func(context.Context) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	})
+	i.Fire(nil)
+
+	<-time.After(60 * time.Millisecond) // Past the idle timeout.
+	if n := atomic.LoadInt32(&ran); n != 0 {
+		t.Errorf("The pre-shutdown func ran %d times after Fire, want none", n)
+	}
+}
+
+// notPreShutdownAfter fails if the pre-shutdown func runs once fire has ended
+// a busy tracker, and its connection is gone.
+func notPreShutdownAfter(t *testing.T, fire func(*netutil.IdleTracker), opts ...netutil.Option) {
+	t.Helper()
+	i := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond, opts...)
+	var ran int32
+	i.SetPreShutdown(func(context.Context) error {
+		atomic.AddInt32(&ran, 1)
+		return nil
+	})
+	c := &netutiltest.Conn{}
+	i.ConnState(c, http.StateActive)
+
+	fire(i)
+	select {
+	case <-i.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("The tracker has not fired")
+	}
+	i.ConnState(c, http.StateClosed)
+	<-time.After(60 * time.Millisecond) // Past the idle timeout.
+	if n := atomic.LoadInt32(&ran); n != 0 {
+		t.Errorf("The pre-shutdown func ran %d times after %v", n, i.Err())
+	}
+}
+
+func TestPreShutdownNotAfterHardDeadline(t *testing.T) {
+	notPreShutdownAfter(t, func(i *netutil.IdleTracker) {
+		i.SetHardDeadline(time.Now().Add(10 * time.Millisecond))
+	})
+}
+
+func TestPreShutdownNotAfterSpike(t *testing.T) {
+	notPreShutdownAfter(t, func(i *netutil.IdleTracker) {
+		var conns []net.Conn
+		for n := 0; n < 3; n++ {
+			conns = append(conns, &netutiltest.Conn{})
+			i.ConnState(conns[n], http.StateNew)
+		}
+		<-i.Done()
+		for _, c := range conns {
+			i.ConnState(c, http.StateClosed)
+		}
+	}, netutil.WithSpikeShutdown(2, 1*time.Second))
+}
+
+func TestPreShutdownNotAfterListenerFailed(t *testing.T) {
+	notPreShutdownAfter(t, func(i *netutil.IdleTracker) {
+		ln := newScriptedListener(errors.New("network is down"))
+		i.ServeRaw(context.Background(), ln, func(context.Context, net.Conn) {})
+	})
+}
+
+func TestPreShutdownErr(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 10*time.Millisecond)
+	flushErr := errors.New("flush failed")
+	i.SetPreShutdown(func(context.Context) error { return flushErr })
+
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("IdleTracker is not done although the pre-shutdown func has failed")
+	}
+	if err := i.PreShutdownErr(); err != flushErr {
+		t.Errorf("PreShutdownErr = %v, want %v", err, flushErr)
+	}
+	if err := i.Err(); err != context.DeadlineExceeded {
+		t.Errorf("Err = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestPreShutdownParentWins(t *testing.T) {
+	parentCtx, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+	i := netutil.NewIdleTracker(parentCtx, 10*time.Millisecond)
+	started, returned := make(chan struct{}), make(chan struct{})
+	i.SetPreShutdown(func(ctx context.Context) error {
+		defer close(returned)
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	select {
+	case <-started:
+	case <-time.After(1 * time.Second):
+		t.Fatal("The pre-shutdown func has not been run")
+	}
+	if isClosed(i.Done()) {
+		t.Fatal("Done was closed before the pre-shutdown func has returned")
+	}
+	cancelParent()
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("Cancelling the parent mid pre-shutdown has not made the tracker done")
+	}
+	if err := i.Err(); err != context.Canceled {
+		t.Errorf("Err = %v, want the parent's context.Canceled", err)
+	}
+	select {
+	case <-returned:
+	case <-time.After(1 * time.Second):
+		t.Fatal("The context passed to the pre-shutdown func has not been cancelled")
+	}
+}
+
+// closeSignalingConn closes its channel on Close, which must happen once at most.
+type closeSignalingConn struct {
+	netutiltest.Conn
+	closed chan struct{}
+}
+
+func (c *closeSignalingConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestFirePolicy(t *testing.T) {
+	// A connection arrives while the pre-shutdown func runs.
+	setup := func(p netutil.FirePolicy) (*netutil.IdleTracker, *closeSignalingConn) {
+		i := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond, netutil.WithFirePolicy(p))
+		late := &closeSignalingConn{closed: make(chan struct{})}
+		var once sync.Once
+		i.SetPreShutdown(func(context.Context) error {
+			once.Do(func() { i.ConnState(late, http.StateActive) })
+			return nil
+		})
+		return i, late
+	}
+
+	t.Run("linger", func(t *testing.T) {
+		i, late := setup(netutil.FireLinger)
+		select {
+		case <-i.Done():
+		case <-time.After(1 * time.Second):
+			t.Fatal("The tracker is not done")
+		}
+		if isClosed(late.closed) || i.ActiveConns() != 1 {
+			t.Error("The late connection should have been left alone")
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		i, late := setup(netutil.FireClose)
+		select {
+		case <-i.Done():
+		case <-time.After(1 * time.Second):
+			t.Fatal("The tracker is not done")
+		}
+		select {
+		case <-late.closed:
+		case <-time.After(1 * time.Second):
+			t.Error("The late connection has not been closed")
+		}
+	})
+
+	t.Run("wait", func(t *testing.T) {
+		i, late := setup(netutil.FireWait)
+		select {
+		case <-i.Done():
+			t.Fatal("The tracker fired while a connection was active")
+		case <-time.After(100 * time.Millisecond):
+		}
+		i.ConnState(late, http.StateClosed)
+		select {
+		case <-i.Done():
+		case <-time.After(1 * time.Second):
+			t.Fatal("The tracker has not fired after the late connection is gone")
+		}
+		if err := i.Err(); err != context.DeadlineExceeded {
+			t.Errorf("Err = %v, want context.DeadlineExceeded", err)
+		}
+	})
+
+	if _, err := netutil.NewIdleTrackerErr(context.Background(), 1*time.Second,
+		netutil.WithFirePolicy(netutil.FirePolicy(42))); err == nil {
+		t.Error("An unknown FirePolicy should have been rejected")
+	}
+}
+
+func TestDrained(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	a, b := &netutiltest.Conn{}, &netutiltest.Conn{}
+	i.ConnState(a, http.StateActive)
+	i.ConnState(b, http.StateActive)
+	i.ConnState(b, http.StateClosed)
+	if isClosed(i.Drained()) {
+		t.Fatal("Drained before the tracker is done")
+	}
+
+	i.Fire(nil)
+	if isClosed(i.Drained()) {
+		t.Fatal("Drained with a connection left")
+	}
+	i.ConnState(a, http.StateClosed)
+	if !isClosed(i.Drained()) {
+		t.Error("Not drained after the last connection has been closed")
+	}
+
+	// Without any connection at the time of firing.
+	i = netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	i.Fire(nil)
+	if !isClosed(i.Drained()) {
+		t.Error("Not drained right away with no connection left")
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// SignalError is what Err returns after NotifyOnSignals has caught a signal.
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (e SignalError) Error() string {
+	return "netutil: received signal " + e.Signal.String()
+}
+
+// NotifyOnSignals has the tracker be done once any of sigs is received,
+// such as syscall.SIGTERM, with a SignalError, so that whatever waits for
+// Done handles shutdown on idling and on signals alike.
+//
+// The returned func removes the handler, so that the signals
+// get their default behaviour again. Call it once done.
+func (t *IdleTracker) NotifyOnSignals(sigs ...os.Signal) context.CancelFunc {
+	caught := make(chan os.Signal, 1)
+	signal.Notify(caught, sigs...)
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-caught:
+			t.fire(SignalError{Signal: sig})
+		case <-t.Done():
+		case <-stop:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(caught)
+			close(stop)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+// This file is released into the public domain.
+
+//go:build !windows
+// +build !windows
+
+package netutil_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func TestNotifyOnSignals(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	stop := i.NotifyOnSignals(syscall.SIGUSR1)
+	defer stop()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatalf("syscall.Kill: %v", err)
+	}
+	select {
+	case <-i.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("The tracker has not fired on the signal")
+	}
+	var sigErr netutil.SignalError
+	if err := i.Err(); !errors.As(err, &sigErr) || sigErr.Signal != syscall.SIGUSR1 {
+		t.Errorf("Err should tell the signal, got: %v", err)
+	}
+}
+
+func TestPreShutdownNotAfterSignal(t *testing.T) {
+	notPreShutdownAfter(t, func(i *netutil.IdleTracker) {
+		stop := i.NotifyOnSignals(syscall.SIGUSR1)
+		t.Cleanup(stop)
+		if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+			t.Fatalf("syscall.Kill: %v", err)
+		}
+	})
+}
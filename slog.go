@@ -0,0 +1,46 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package netutil
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// WithSlogLogger has the tracker log its lifecycle events to logger, see
+// EventKind, with the active connection count, and as applicable the
+// remote address, deadline, or error as attributes. Events of single
+// connections are logged at level Debug, EventDeadlineClamped at Warn,
+// all others at Info.
+func WithSlogLogger(logger *slog.Logger) Option {
+	if logger == nil {
+		return func(*IdleTracker) error {
+			return errors.New("netutil: WithSlogLogger needs a non-nil logger")
+		}
+	}
+	return WithEventHook(func(ev Event) {
+		level := slog.LevelInfo
+		attrs := []slog.Attr{slog.Int("active_conns", ev.ActiveConns)}
+		switch ev.Kind {
+		case EventConnNew, EventConnClosed:
+			level = slog.LevelDebug
+			if ev.RemoteAddr != nil {
+				attrs = append(attrs, slog.String("remote_addr", ev.RemoteAddr.String()))
+			}
+		case EventIdle, EventDeadlineReset:
+			attrs = append(attrs, slog.Time("deadline", ev.Deadline))
+		case EventDeadlineClamped:
+			level = slog.LevelWarn
+			attrs = append(attrs, slog.Time("deadline", ev.Deadline))
+		case EventFired:
+			attrs = append(attrs, slog.String("err", ev.Err.Error()))
+		}
+		logger.LogAttrs(context.Background(), level, ev.Kind.String(), attrs...)
+	})
+}
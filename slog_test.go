@@ -0,0 +1,81 @@
+// This file is released into the public domain.
+
+//go:build go1.21
+// +build go1.21
+
+package netutil_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func TestWithSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	i := netutil.NewIdleTracker(context.Background(), 10*time.Millisecond,
+		netutil.WithSlogLogger(logger))
+
+	conn := newRemoteConn(t, "192.0.2.1:4711")
+	i.ConnState(conn, http.StateNew)
+	i.ConnState(conn, http.StateActive)
+	i.ConnState(conn, http.StateClosed)
+	select {
+	case <-i.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("The tracker did not fire")
+	}
+
+	var records []map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var rec map[string]interface{}
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatalf("Log output is not JSON: %v", err)
+		}
+		records = append(records, rec)
+	}
+
+	want := []struct {
+		msg, level string
+		active     float64
+	}{
+		{"conn-new", "DEBUG", 1},
+		{"conn-closed", "DEBUG", 0},
+		{"idle-entered", "INFO", 0},
+		{"fired", "INFO", 0},
+		{"drained", "INFO", 0},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("Got %d records, want %d: %v", len(records), len(want), records)
+	}
+	for n, w := range want {
+		rec := records[n]
+		if rec["msg"] != w.msg || rec["level"] != w.level || rec["active_conns"] != w.active {
+			t.Errorf("Record %d = %v, want msg=%s level=%s active_conns=%v", n, rec, w.msg, w.level, w.active)
+		}
+	}
+	if v := records[0]["remote_addr"]; v != "192.0.2.1:4711" {
+		t.Errorf("conn-new has remote_addr=%v", v)
+	}
+	if _, ok := records[2]["deadline"]; !ok {
+		t.Error("idle-entered lacks deadline")
+	}
+	if records[3]["err"] != context.DeadlineExceeded.Error() {
+		t.Errorf("fired has err=%v, want %v", records[3]["err"], context.DeadlineExceeded)
+	}
+}
+
+func TestWithSlogLoggerNil(t *testing.T) {
+	if _, err := netutil.NewIdleTrackerErr(context.Background(), time.Minute,
+		netutil.WithSlogLogger(nil)); err == nil {
+		t.Error("WithSlogLogger(nil) should have been rejected")
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+)
+
+// ErrNotTLS is returned by SNIPeek if the connection doesn't start with a TLS ClientHello.
+var ErrNotTLS = errors.New("netutil: not a TLS ClientHello")
+
+// errHelloCaptured aborts the handshake once the ClientHello has been read.
+var errHelloCaptured = errors.New("netutil: ClientHello captured")
+
+// SNIPeek reads the TLS ClientHello from c and returns the server name it
+// asks for, without terminating TLS. This is empty if the client didn't
+// indicate any. The returned rewound connection replays what has been read,
+// for a downstream tls.Server to see the full handshake.
+//
+// Set a read deadline on c beforehand, else a slow client could block this
+// indefinitely. If c doesn't speak TLS, ErrNotTLS is returned along with
+// the rewound connection.
+func SNIPeek(c net.Conn) (serverName string, rewound net.Conn, err error) {
+	var buf bytes.Buffer
+	var hello *tls.ClientHelloInfo
+	err = tls.Server(readOnlyConn{r: io.TeeReader(c, &buf), Conn: c}, &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = h
+			return nil, errHelloCaptured
+		},
+	}).Handshake()
+
+	rewound = &rewoundConn{Conn: c, r: io.MultiReader(&buf, c)}
+	if hello != nil {
+		return hello.ServerName, rewound, nil
+	}
+	if ne, ok := err.(net.Error); ok {
+		return "", rewound, ne // Such as a timeout.
+	}
+	return "", rewound, ErrNotTLS
+}
+
+// readOnlyConn keeps the handshake from writing anything, such as an alert.
+type readOnlyConn struct {
+	r io.Reader
+	net.Conn
+}
+
+func (c readOnlyConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c readOnlyConn) Write(b []byte) (int, error) { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                { return nil }
+
+// rewoundConn replays what has been read before.
+type rewoundConn struct {
+	net.Conn
+	r io.Reader
+}
+
+// Read implements net.Conn.
+func (c *rewoundConn) Read(b []byte) (int, error) { return c.r.Read(b) }
@@ -0,0 +1,74 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+// replayConn reads from a fixed buffer.
+type replayConn struct {
+	netutiltest.Conn
+	r io.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+// captureClientHello returns the first record a TLS client sends.
+func captureClientHello(t *testing.T, serverName string) []byte {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(server, header); err != nil {
+		t.Fatalf("Reading the record header: %v", err)
+	}
+	record := make([]byte, binary.BigEndian.Uint16(header[3:]))
+	if _, err := io.ReadFull(server, record); err != nil {
+		t.Fatalf("Reading the record: %v", err)
+	}
+	return append(header, record...)
+}
+
+func TestSNIPeek(t *testing.T) {
+	hello := captureClientHello(t, "example.com")
+	c := &replayConn{r: bytes.NewReader(append(hello, "more"...))}
+
+	name, rewound, err := netutil.SNIPeek(c)
+	if err != nil {
+		t.Fatalf("SNIPeek: %v", err)
+	}
+	if name != "example.com" {
+		t.Errorf("serverName = %q, want \"example.com\"", name)
+	}
+	replayed, _ := ioutil.ReadAll(rewound)
+	if want := append(hello, "more"...); !bytes.Equal(replayed, want) {
+		t.Errorf("The rewound connection does not replay what has been sent,\n -- got: %x\n -- want: %x", replayed, want)
+	}
+}
+
+func TestSNIPeekNotTLS(t *testing.T) {
+	const request = "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	c := &replayConn{r: bytes.NewReader([]byte(request))}
+
+	_, rewound, err := netutil.SNIPeek(c)
+	if err != netutil.ErrNotTLS {
+		t.Errorf("Expected ErrNotTLS, got: %v", err)
+	}
+	if replayed, _ := ioutil.ReadAll(rewound); string(replayed) != request {
+		t.Errorf("The rewound connection does not replay what has been sent: %q", replayed)
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrAcceptSpike is what a tracker fires with on too many new connections
+// in a short time, see WithSpikeShutdown.
+var ErrAcceptSpike = errors.New("netutil: spike in new connections")
+
+// spikeDetector tells whether more than threshold connections have been
+// new within the sliding window. It keeps the times of the latest
+// threshold ones in a ring, the oldest of which is up next.
+type spikeDetector struct {
+	window time.Duration
+	times  []time.Time
+	next   int
+}
+
+// observe records a new connection and reports a spike.
+func (s *spikeDetector) observe(now time.Time) bool {
+	oldest := s.times[s.next]
+	s.times[s.next] = now
+	s.next = (s.next + 1) % len(s.times)
+	return !oldest.IsZero() && now.Sub(oldest) < s.window
+}
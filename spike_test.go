@@ -0,0 +1,47 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestSpikeShutdown(t *testing.T) {
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithClock(clock.Now),
+		netutil.WithSpikeShutdown(3, 1*time.Second))
+	connect := func(n int, every time.Duration) {
+		for ; n > 0; n-- {
+			c := &netutiltest.Conn{}
+			i.ConnState(c, http.StateNew)
+			i.ConnState(c, http.StateClosed)
+			clock.Advance(every)
+		}
+	}
+
+	// At the threshold, and then spread out over more than the window.
+	connect(3, 100*time.Millisecond)
+	clock.Advance(1 * time.Second)
+	connect(6, 400*time.Millisecond)
+	select {
+	case <-i.Done():
+		t.Fatalf("Fired without a spike: %v", i.Err())
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	connect(4, 10*time.Millisecond)
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("The tracker did not fire on the spike")
+	}
+	if err := i.Err(); err != netutil.ErrAcceptSpike {
+		t.Errorf("Err = %v, want: %v", err, netutil.ErrAcceptSpike)
+	}
+}
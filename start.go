@@ -0,0 +1,26 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+// Start has a tracker created WithDeferredStart count down its patience,
+// unless anything keeps it busy. Only the first call, or the first
+// ConnState, arms the timer; calling it again has no effect.
+// It is safe to call from several goroutines.
+func (t *IdleTracker) Start() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.startLocked()
+}
+
+// startLocked is Start with the write lock held.
+func (t *IdleTracker) startLocked() {
+	if !t.awaitingStart {
+		return
+	}
+	t.awaitingStart = false
+	if t.busy() == 0 {
+		t.startIdling()
+	}
+}
@@ -0,0 +1,87 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestDeferredStart(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond, netutil.WithDeferredStart())
+	if _, ok := i.Deadline(); ok {
+		t.Error("A tracker that has not been started should not have a deadline")
+	}
+	if !i.Ready() {
+		t.Error("A tracker that has not been started should be ready")
+	}
+	select {
+	case <-i.Done():
+		t.Fatal("The tracker fired before it has been started")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	c := &netutiltest.Conn{}
+	i.ConnState(c, http.StateNew)
+	if _, ok := i.Deadline(); ok {
+		t.Error("With a connection active there should be no deadline")
+	}
+	before := time.Now()
+	i.ConnState(c, http.StateClosed)
+	if d, ok := i.Deadline(); !ok || d.Before(before.Add(20*time.Millisecond)) {
+		t.Errorf("The countdown should have begun with the connection gone, got: %v, %v", d, ok)
+	}
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("The tracker has not fired after having been started")
+	}
+}
+
+func TestDeferredStartExplicit(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond, netutil.WithDeferredStart())
+	before := time.Now()
+	i.Start()
+	i.Start()
+	if d, ok := i.Deadline(); !ok || d.Before(before.Add(20*time.Millisecond)) {
+		t.Errorf("Start should have begun the countdown, got: %v, %v", d, ok)
+	}
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("The tracker has not fired after Start")
+	}
+}
+
+func TestDeferredStartConcurrently(t *testing.T) {
+	var armed int // Guarded by the tracker's lock.
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithDeferredStart(),
+		netutil.WithEventHook(func(ev netutil.Event) {
+			if ev.Kind == netutil.EventIdle || ev.Kind == netutil.EventDeadlineReset {
+				armed++
+			}
+		}))
+
+	var wg sync.WaitGroup
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			i.Start()
+		}()
+	}
+	wg.Wait()
+
+	if _, ok := i.Deadline(); !ok {
+		t.Error("Start should have begun the countdown")
+	}
+	if armed != 1 {
+		t.Errorf("The timer has been armed %d times, want once", armed)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInvalidState is returned by LoadIdleTracker for state
+// that has not been produced by MarshalState.
+var ErrInvalidState = errors.New("netutil: invalid tracker state")
+
+const (
+	stateMagic   = "nIT"
+	stateVersion = 1
+	stateValues  = 7 + len(Stats{}.ConnStates) + len(Stats{}.FirstByte)
+	stateLen     = len(stateMagic) + 1 + stateValues*8 + 4 + 1
+)
+
+// MarshalState encodes what LoadIdleTracker needs to carry on with the
+// idle accounting in another process, such as across an exec-based upgrade
+// that passes the listening socket along.
+//
+// What survives: the patience, the time left until the deadline if idle,
+// the accounting of Stats, EverActive, and the number of active connections.
+// What doesn't: the connections themselves, options, anything set by
+// SetPreShutdown, and the parent. Times are relative to the call of MarshalState.
+func (t *IdleTracker) MarshalState() []byte {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	now := t.now()
+	idleDuration, activeDuration := t.idleDuration, t.activeDuration
+	if t.idle {
+		idleDuration += now.Sub(t.since)
+	} else {
+		activeDuration += now.Sub(t.since)
+	}
+	var remaining time.Duration
+	if t.busy() == 0 && !t.awaitingStart {
+		remaining = t.deadline.Sub(now)
+		if remaining <= 0 {
+			remaining = 1 // Zero stands for “not idling.”
+		}
+	}
+
+	b := make([]byte, stateLen)
+	n := copy(b, stateMagic)
+	b[n] = stateVersion
+	n++
+	values := []int64{
+		int64(t.patience), int64(remaining),
+		int64(idleDuration), int64(activeDuration),
+		atomic.LoadInt64(&t.bytesIn), atomic.LoadInt64(&t.bytesOut),
+	}
+	for k := range t.stateCounts {
+		values = append(values, atomic.LoadInt64(&t.stateCounts[k]))
+	}
+	values = append(values, int64(t.maxIdleGap))
+	for k := range t.firstByteCounts {
+		values = append(values, atomic.LoadInt64(&t.firstByteCounts[k]))
+	}
+	for _, v := range values {
+		binary.BigEndian.PutUint64(b[n:], uint64(v))
+		n += 8
+	}
+	binary.BigEndian.PutUint32(b[n:], uint32(t.dangling.len()))
+	n += 4
+	if t.everActive {
+		b[n] = 1
+	}
+	return b
+}
+
+// LoadIdleTracker is NewIdleTrackerErr for the state from MarshalState.
+//
+// If idle at the time, the new tracker keeps the old deadline,
+// else it starts out with the full patience: any connections
+// that had been active will get reported anew by the server.
+// Pass the options again, as they are not part of the state.
+func LoadIdleTracker(parent context.Context, state []byte, opts ...Option) (*IdleTracker, error) {
+	if len(state) != stateLen || string(state[:len(stateMagic)]) != stateMagic ||
+		state[len(stateMagic)] != stateVersion {
+		return nil, ErrInvalidState
+	}
+	n := len(stateMagic) + 1
+	v := make([]int64, stateValues)
+	for k := range v {
+		v[k] = int64(binary.BigEndian.Uint64(state[n:]))
+		n += 8
+		if v[k] < 0 {
+			return nil, ErrInvalidState
+		}
+	}
+	patience, remaining := time.Duration(v[0]), time.Duration(v[1])
+	if patience <= 0 {
+		return nil, ErrInvalidState
+	}
+	n += 4 // The number of connections is informational only.
+
+	t, err := NewIdleTrackerErr(parent, patience, opts...)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.idleDuration, t.activeDuration = time.Duration(v[2]), time.Duration(v[3])
+	atomic.StoreInt64(&t.bytesIn, v[4])
+	atomic.StoreInt64(&t.bytesOut, v[5])
+	for k := range t.stateCounts {
+		atomic.StoreInt64(&t.stateCounts[k], v[6+k])
+	}
+	rest := v[6+len(t.stateCounts):]
+	t.maxIdleGap = time.Duration(rest[0])
+	for k := range t.firstByteCounts {
+		atomic.StoreInt64(&t.firstByteCounts[k], rest[1+k])
+	}
+	t.everActive = state[n] == 1
+	if remaining > 0 && t.permErr == nil && t.busy() == 0 && !t.awaitingStart {
+		t.timer.Stop()
+		t.timer.Reset(remaining)
+		t.deadline = t.since.Add(remaining)
+	}
+	return t, nil
+}
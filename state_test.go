@@ -0,0 +1,96 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestMarshalStateRoundTrip(t *testing.T) {
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithClock(clock.Now))
+
+	c := &netutiltest.Conn{}
+	clock.Advance(1 * time.Minute)
+	i.ConnState(c, http.StateActive)
+	clock.Advance(10 * time.Minute)
+	i.ConnState(c, http.StateClosed)
+	clock.Advance(5 * time.Minute)
+	want := i.Stats()
+	state := i.MarshalState()
+
+	clock2 := newFakeClock()
+	clock2.Advance(24 * time.Hour)
+	j, err := netutil.LoadIdleTracker(context.Background(), state, netutil.WithClock(clock2.Now))
+	if err != nil {
+		t.Fatalf("LoadIdleTracker: %v", err)
+	}
+	if got := j.Stats(); got != want {
+		t.Errorf("Stats did not survive, got: %+v, want: %+v", got, want)
+	}
+	if !j.EverActive() {
+		t.Error("EverActive did not survive")
+	}
+	if d, _ := j.Deadline(); !d.Equal(clock2.Now().Add(55 * time.Minute)) {
+		t.Errorf("The time left until the deadline did not survive, deadline: %v", d)
+	}
+
+	// Round-trips again.
+	if got, want := string(j.MarshalState()), string(state); got != want {
+		t.Errorf("The state of the loaded tracker differs:\n%x\n%x", got, want)
+	}
+}
+
+func TestMarshalStateWhileActive(t *testing.T) {
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithClock(clock.Now))
+	i.ConnState(&netutiltest.Conn{}, http.StateActive)
+	clock.Advance(2 * time.Hour)
+
+	j, err := netutil.LoadIdleTracker(context.Background(), i.MarshalState(), netutil.WithClock(clock.Now))
+	if err != nil {
+		t.Fatalf("LoadIdleTracker: %v", err)
+	}
+	if d, _ := j.Deadline(); !d.Equal(clock.Now().Add(1 * time.Hour)) {
+		t.Errorf("With connections active, the full patience should apply, deadline: %v", d)
+	}
+	if s := j.Stats(); s.ActiveDuration != 2*time.Hour {
+		t.Errorf("ActiveDuration = %v, want 2h", s.ActiveDuration)
+	}
+}
+
+func TestLoadIdleTrackerDeferredStart(t *testing.T) {
+	state := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond).MarshalState()
+	j, err := netutil.LoadIdleTracker(context.Background(), state, netutil.WithDeferredStart())
+	if err != nil {
+		t.Fatalf("LoadIdleTracker: %v", err)
+	}
+	if _, onDeadline := j.Deadline(); onDeadline {
+		t.Error("Created WithDeferredStart, the tracker should not be on a deadline before Start")
+	}
+	select {
+	case <-j.Done():
+		t.Fatal("The tracker fired before Start")
+	case <-time.After(60 * time.Millisecond):
+	}
+}
+
+func TestLoadIdleTrackerInvalid(t *testing.T) {
+	state := netutil.NewIdleTracker(context.Background(), 1*time.Hour).MarshalState()
+	for _, b := range [][]byte{
+		nil,
+		[]byte("garbage"),
+		state[:len(state)-1],
+		append([]byte("X"), state[1:]...),
+	} {
+		if _, err := netutil.LoadIdleTracker(context.Background(), b); err != netutil.ErrInvalidState {
+			t.Errorf("LoadIdleTracker(%x) = %v, want ErrInvalidState", b, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the counters an IdleTracker maintains.
+type Stats struct {
+	// IdleDuration is the cumulative time spent without any connection,
+	// that is, while waiting for the patience to run out.
+	IdleDuration time.Duration
+	// ActiveDuration is the cumulative time spent with at least one connection.
+	ActiveDuration time.Duration
+	// MaxIdleGap is the longest idle period that has ended, be it by a
+	// connection arriving just in time, or by the tracker firing.
+	// Compare it to the patience to learn how close calls get.
+	MaxIdleGap time.Duration
+
+	// BytesIn and BytesOut are the totals read from and written to
+	// connections accepted through WrapListener. Connections reported
+	// by ConnState only don't count, hence these stay zero without it.
+	BytesIn, BytesOut int64
+
+	// ConnStates counts how often each http.ConnState has been reported,
+	// indexed by it. A high ratio of StateNew to StateActive, for example,
+	// stands for many connections that never send a request.
+	ConnStates [http.StateClosed + 1]int64
+
+	// FirstByte counts connections accepted through WrapListener by how
+	// long after Accept their first byte has been read. Index k counts those
+	// up to FirstByteBounds[k], the last one all that took longer.
+	FirstByte [len(FirstByteBounds) + 1]int64
+}
+
+// Stats returns a snapshot of the counters,
+// including the current (ongoing) idle or active period.
+func (t *IdleTracker) Stats() Stats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	s := Stats{
+		IdleDuration:   t.idleDuration,
+		ActiveDuration: t.activeDuration,
+		MaxIdleGap:     t.maxIdleGap,
+		BytesIn:        atomic.LoadInt64(&t.bytesIn),
+		BytesOut:       atomic.LoadInt64(&t.bytesOut),
+	}
+	for k := range s.ConnStates {
+		s.ConnStates[k] = atomic.LoadInt64(&t.stateCounts[k])
+	}
+	for k := range s.FirstByte {
+		s.FirstByte[k] = atomic.LoadInt64(&t.firstByteCounts[k])
+	}
+	partial := t.now().Sub(t.since)
+	if t.idle {
+		s.IdleDuration += partial
+	} else {
+		s.ActiveDuration += partial
+	}
+	return s
+}
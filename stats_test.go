@@ -0,0 +1,127 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+// fakeClock only advances when told so.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func TestStatsIdleVsActive(t *testing.T) {
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+		netutil.WithClock(clock.Now))
+	a, b := &netutiltest.Conn{}, &netutiltest.Conn{}
+
+	clock.Advance(3 * time.Second) // idle
+	i.ConnState(a, http.StateNew)
+	clock.Advance(2 * time.Second) // active
+	i.ConnState(b, http.StateNew)
+	clock.Advance(5 * time.Second) // still active, with two
+	i.ConnState(a, http.StateClosed)
+	clock.Advance(1 * time.Second) // still active, with one
+	i.ConnState(b, http.StateIdle)
+	clock.Advance(7 * time.Second) // idle again
+
+	s := i.Stats()
+	if want := 10 * time.Second; s.IdleDuration != want {
+		t.Errorf("IdleDuration = %v, want %v", s.IdleDuration, want)
+	}
+	if want := 8 * time.Second; s.ActiveDuration != want {
+		t.Errorf("ActiveDuration = %v, want %v", s.ActiveDuration, want)
+	}
+
+	// The ongoing period gets included, too.
+	i.ConnState(b, http.StateActive)
+	clock.Advance(4 * time.Second)
+	s = i.Stats()
+	if want := 10 * time.Second; s.IdleDuration != want {
+		t.Errorf("IdleDuration = %v, want %v", s.IdleDuration, want)
+	}
+	if want := 12 * time.Second; s.ActiveDuration != want {
+		t.Errorf("ActiveDuration = %v, want %v", s.ActiveDuration, want)
+	}
+}
+
+func TestStatsConnStates(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	netutiltest.DriveConnState(i, []http.ConnState{
+		http.StateNew, http.StateActive, http.StateIdle, http.StateActive, http.StateClosed,
+		http.StateNew, http.StateClosed,
+		http.StateNew, http.StateActive, http.StateHijacked,
+	})
+
+	want := [...]int64{
+		http.StateNew:      3,
+		http.StateActive:   3,
+		http.StateIdle:     1,
+		http.StateHijacked: 1,
+		http.StateClosed:   2,
+	}
+	if got := i.Stats().ConnStates; got != want {
+		t.Errorf("ConnStates = %v, want %v", got, want)
+	}
+}
+
+func TestStatsMaxIdleGap(t *testing.T) {
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+		netutil.WithClock(clock.Now))
+	c := &netutiltest.Conn{}
+
+	for _, gap := range []time.Duration{3 * time.Minute, 50 * time.Minute, 2 * time.Minute} {
+		clock.Advance(gap)
+		i.ConnState(c, http.StateActive)
+		clock.Advance(1 * time.Second)
+		i.ConnState(c, http.StateIdle)
+	}
+	clock.Advance(55 * time.Minute) // Ongoing, hence not counted yet.
+	if got, want := i.Stats().MaxIdleGap, 50*time.Minute; got != want {
+		t.Errorf("MaxIdleGap = %v, want %v", got, want)
+	}
+	i.ConnState(c, http.StateActive)
+	if got, want := i.Stats().MaxIdleGap, 55*time.Minute; got != want {
+		t.Errorf("MaxIdleGap = %v, want %v", got, want)
+	}
+}
+
+func TestStatsMaxIdleGapOnFire(t *testing.T) {
+	const patience = 30 * time.Millisecond
+	i := netutil.NewIdleTracker(context.Background(), patience)
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("The tracker has not fired")
+	}
+	if got := i.Stats().MaxIdleGap; got < patience || got > 20*patience {
+		t.Errorf("MaxIdleGap = %v, want about the patience of %v", got, patience)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"time"
+)
+
+// TimerFactory creates the timer that fires once patience runs out.
+//
+// It returns the channel to receive the tick on, and functions with
+// the semantics of time.Timer's Stop and Reset.
+type TimerFactory func(d time.Duration) (c <-chan time.Time, stop func(), reset func(time.Duration) bool)
+
+// newRealTimer is the default TimerFactory.
+func newRealTimer(d time.Duration) (<-chan time.Time, func(), func(time.Duration) bool) {
+	t := time.NewTimer(d)
+	return t.C, func() { t.Stop() }, t.Reset
+}
+
+// timerHandle wraps what a TimerFactory returned to look like a time.Timer.
+type timerHandle struct {
+	C     <-chan time.Time
+	stop  func()
+	reset func(time.Duration) bool
+}
+
+func newTimerHandle(factory TimerFactory, d time.Duration) *timerHandle {
+	c, stop, reset := factory(d)
+	return &timerHandle{C: c, stop: stop, reset: reset}
+}
+
+func (h *timerHandle) Stop() {
+	h.stop()
+}
+
+func (h *timerHandle) Reset(d time.Duration) bool {
+	return h.reset(d)
+}
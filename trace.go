@@ -0,0 +1,111 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// WithTraceHook has the tracker report when its deadline has been set or
+// reset, it fires, and it is drained to fn, for bridging to a tracer
+// such as OpenTelemetry's without this package depending on one.
+// event is the EventKind as string, such as "fired", and attrs are:
+//
+//	netutil.active_conns  int, always
+//	netutil.deadline      string in RFC 3339 format, on "idle-entered" and "deadline-reset"
+//	netutil.err           string, on "fired"
+//	netutil.dropped       int, on the first event after any have been dropped
+//
+// fn gets called in order, from a goroutine of its own and without any
+// lock held, hence can take its time. Should it fall behind by more than
+// 256 events, the oldest get dropped. For example, to add the events to
+// a span:
+//
+//	netutil.WithTraceHook(func(event string, attrs map[string]interface{}) {
+//		kv := make([]attribute.KeyValue, 0, len(attrs))
+//		for k, v := range attrs {
+//			switch v := v.(type) {
+//			case int:
+//				kv = append(kv, attribute.Int(k, v))
+//			case string:
+//				kv = append(kv, attribute.String(k, v))
+//			}
+//		}
+//		span.AddEvent(event, trace.WithAttributes(kv...))
+//	})
+func WithTraceHook(fn func(event string, attrs map[string]interface{})) Option {
+	if fn == nil {
+		return func(*IdleTracker) error {
+			return errors.New("netutil: WithTraceHook needs a non-nil func")
+		}
+	}
+	q := &traceQueue{fn: fn}
+	return WithEventHook(func(ev Event) {
+		switch ev.Kind {
+		case EventIdle, EventDeadlineReset, EventFired, EventDrained:
+			q.push(ev)
+		}
+	})
+}
+
+// traceQueueLen is how many events a trace hook can fall behind.
+const traceQueueLen = 256
+
+// traceQueue delivers events to a trace hook in order, from a goroutine
+// that lives only as long as there are any.
+type traceQueue struct {
+	fn func(string, map[string]interface{})
+
+	mu      sync.Mutex
+	pending []Event
+	dropped int // Since the last event delivered.
+	running bool
+}
+
+func (q *traceQueue) push(ev Event) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) >= traceQueueLen {
+		q.pending[0] = Event{}
+		q.pending = q.pending[1:]
+		q.dropped++
+	}
+	q.pending = append(q.pending, ev)
+	if !q.running {
+		q.running = true
+		go q.deliver()
+	}
+}
+
+func (q *traceQueue) deliver() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		ev := q.pending[0]
+		q.pending[0] = Event{}
+		q.pending = q.pending[1:]
+		dropped := q.dropped
+		q.dropped = 0
+		q.mu.Unlock()
+
+		attrs := map[string]interface{}{"netutil.active_conns": ev.ActiveConns}
+		if dropped > 0 {
+			attrs["netutil.dropped"] = dropped
+		}
+		switch ev.Kind {
+		case EventIdle, EventDeadlineReset:
+			attrs["netutil.deadline"] = ev.Deadline.Format(time.RFC3339Nano)
+		case EventFired:
+			attrs["netutil.err"] = ev.Err.Error()
+		}
+		q.fn(ev.Kind.String(), attrs)
+	}
+}
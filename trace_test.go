@@ -0,0 +1,99 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestWithTraceHook(t *testing.T) {
+	type traced struct {
+		event string
+		attrs map[string]interface{}
+	}
+	got := make(chan traced, 10)
+	clock := newFakeClock()
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour, netutil.WithClock(clock.Now),
+		netutil.WithTraceHook(func(event string, attrs map[string]interface{}) {
+			got <- traced{event, attrs}
+		}))
+
+	c := &netutiltest.Conn{}
+	i.ConnState(c, http.StateNew)
+	i.ConnState(c, http.StateClosed)
+	i.Fire(nil)
+
+	want := []traced{
+		{"idle-entered", map[string]interface{}{
+			"netutil.active_conns": 0,
+			"netutil.deadline":     clock.Now().Add(1 * time.Hour).Format(time.RFC3339Nano),
+		}},
+		{"fired", map[string]interface{}{
+			"netutil.active_conns": 0,
+			"netutil.err":          context.DeadlineExceeded.Error(),
+		}},
+		{"drained", map[string]interface{}{"netutil.active_conns": 0}},
+	}
+	for _, w := range want {
+		select {
+		case g := <-got:
+			if g.event != w.event || !reflect.DeepEqual(g.attrs, w.attrs) {
+				t.Errorf("Got %q %v, want: %q %v", g.event, g.attrs, w.event, w.attrs)
+			}
+		case <-time.After(1 * time.Second):
+			t.Fatalf("Event %q has not been reported", w.event)
+		}
+	}
+	select {
+	case g := <-got:
+		t.Errorf("Unexpected event %q %v", g.event, g.attrs)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestWithTraceHookBounded(t *testing.T) {
+	unblock := make(chan struct{})
+	var (
+		delivered int
+		dropped   interface{}
+	)
+	finished := make(chan struct{})
+	i := netutil.NewIdleTracker(context.Background(), 1*time.Hour,
+		netutil.WithTraceHook(func(event string, attrs map[string]interface{}) {
+			<-unblock
+			delivered++
+			if n, found := attrs["netutil.dropped"]; found {
+				dropped = n
+			}
+			if event == "drained" {
+				close(finished)
+			}
+		}))
+
+	c := &netutiltest.Conn{}
+	for n := 0; n < 1000; n++ {
+		i.ConnState(c, http.StateActive)
+		i.ConnState(c, http.StateClosed) // "deadline-reset"
+	}
+	i.Fire(nil)
+	close(unblock)
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("The last event has not been delivered")
+	}
+
+	if delivered > 257 { // One could have been taken before the queue was full.
+		t.Errorf("%d events have been delivered to a blocked hook, want the queue bounded", delivered)
+	}
+	if n, _ := dropped.(int); n+delivered < 1002 {
+		t.Errorf("netutil.dropped = %v, with %d delivered of at least 1002", dropped, delivered)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"net"
+	"net/http"
+	"sync"
+)
+
+// transfers serializes TransferTo, which locks two trackers,
+// so that transfers in opposite directions cannot deadlock.
+// It guards IdleTracker.forward in addition to the tracker's lock.
+var transfers sync.Mutex
+
+// TransferTo hands the tracked connections and the deadline over to dst,
+// for when a listener gets replaced within the same process, such as
+// to change its TLS configuration. This avoids starting over with the
+// full patience.
+//
+// Afterwards t forwards any ConnState to dst, so that connections
+// which straddle the swap get accounted for. t itself won't fire anymore,
+// unless by its parent. Requests in flight through t.Handler, holds, and
+// Pause stay with t, and their end doesn't start its timer again. Should
+// they be all that keeps t busy, an idle dst starts over with its own
+// patience instead of taking over t's deadline.
+//
+// A transfer to a tracker that forwards to t, be it through others,
+// is ignored, for ConnState would go round in circles.
+func (t *IdleTracker) TransferTo(dst *IdleTracker) {
+	transfers.Lock()
+	defer transfers.Unlock()
+	for fwd := dst; fwd != nil; fwd = fwd.forward {
+		if fwd == t {
+			return
+		}
+	}
+	if t.name != "" {
+		defer unregister(t) // It's dst that carries on.
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+
+	wasBusy, srcIdle := dst.busy() > 0, t.busy() == 0
+	t.dangling.each(func(key interface{}, ci *connInfo) bool {
+		dst.dangling.put(key, ci)
+		return true
+	})
+	if t.concurrent {
+		t.dangling = newConnSet(connShards)
+	} else {
+		t.dangling = newConnSet(0)
+	}
+	t.checkDrained()
+	t.timer.Stop()
+	t.forward = dst
+
+	switch {
+	case dst.busy() == 0 && !srcIdle:
+		dst.startIdling()
+	case dst.busy() == 0:
+		dst.timer.Stop()
+		dst.timer.Reset(t.deadline.Sub(dst.now()))
+		dst.deadline = t.deadline
+	case !wasBusy:
+		dst.stopIdling()
+	}
+}
+
+// Adopt tracks connections that have been accepted elsewhere as active,
+// such as those of a listener that is no longer served through t.
+// Report their end by ConnState, or use Disown.
+func (t *IdleTracker) Adopt(conns ...net.Conn) {
+	for _, c := range conns {
+		t.connState(c, http.StateActive, 0)
+	}
+}
+
+// Disown stops tracking the connection without closing it, as if it had
+// reached http.StateClosed, re-arming the timer if it was the last one.
+// Unlike with Evict, the connection remains the caller's to close.
+func (t *IdleTracker) Disown(c net.Conn) {
+	key := t.keyOf(c)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, found := t.dangling.get(key); !found {
+		return
+	}
+	t.untrack(key)
+	if t.busy() == 0 {
+		t.startIdling()
+	}
+}
@@ -0,0 +1,155 @@
+// This file is released into the public domain.
+
+package netutil_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+	"github.com/wmark/go.netutil/netutiltest"
+)
+
+func TestTransferToWithActiveConns(t *testing.T) {
+	src := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	dst := netutil.NewIdleTracker(context.Background(), 50*time.Millisecond)
+	straddling := &netutiltest.Conn{}
+	src.ConnState(straddling, http.StateActive)
+
+	src.TransferTo(dst)
+	if _, onDeadline := dst.Deadline(); onDeadline {
+		t.Fatal("The destination has taken over an active connection, but is on a deadline")
+	}
+	<-time.After(60 * time.Millisecond)
+	if isClosed(dst.Done()) {
+		t.Fatal("The destination is done despite the active connection")
+	}
+
+	// The old server reports the end of the connection to the old tracker.
+	src.ConnState(straddling, http.StateClosed)
+	if _, onDeadline := dst.Deadline(); !onDeadline {
+		t.Error("The straddling connection has been closed, but the destination is not on a deadline")
+	}
+	select {
+	case <-dst.Done():
+	case <-time.After(1 * time.Second):
+		t.Error("The destination is not done after the straddling connection has been closed")
+	}
+	if isClosed(src.Done()) {
+		t.Error("The source should not fire after the transfer")
+	}
+}
+
+func TestTransferToWhileIdle(t *testing.T) {
+	src := netutil.NewIdleTracker(context.Background(), 50*time.Millisecond)
+	dst := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	want, _ := src.Deadline()
+
+	src.TransferTo(dst)
+	if got, onDeadline := dst.Deadline(); !onDeadline || !got.Equal(want) {
+		t.Errorf("The destination has not taken over the deadline, got %v, want %v", got, want)
+	}
+	select {
+	case <-dst.Done():
+	case <-time.After(1 * time.Second):
+		t.Error("The destination is not done at the deadline it took over")
+	}
+}
+
+func TestAdoptDisown(t *testing.T) {
+	i := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond)
+	a, b := &closeCountingConn{}, &netutiltest.Conn{}
+	i.Adopt(a, b)
+	if n := i.ActiveConns(); n != 2 {
+		t.Fatalf("ActiveConns = %d after adopting two", n)
+	}
+	select {
+	case <-i.Done():
+		t.Fatal("The tracker fired with adopted connections")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	i.ConnState(b, http.StateClosed)
+	i.Disown(a)
+	i.Disown(a) // Not tracked anymore, no effect.
+	if a.closed != 0 {
+		t.Error("Disown has closed the connection")
+	}
+	select {
+	case <-i.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("The tracker has not fired after the adopted connections are gone")
+	}
+}
+
+func TestTransferToBothWays(t *testing.T) {
+	for n := 0; n < 100; n++ {
+		a := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+		b := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+		a.ConnState(&netutiltest.Conn{}, http.StateActive)
+		b.ConnState(&netutiltest.Conn{}, http.StateActive)
+
+		transferred := make(chan struct{}, 2)
+		go func() { a.TransferTo(b); transferred <- struct{}{} }()
+		go func() { b.TransferTo(a); transferred <- struct{}{} }()
+		for k := 0; k < 2; k++ {
+			select {
+			case <-transferred:
+			case <-time.After(2 * time.Second):
+				t.Fatal("Transfers in opposite directions have deadlocked")
+			}
+		}
+
+		// Whichever went first, the other one has been ignored.
+		c := &netutiltest.Conn{}
+		a.ConnState(c, http.StateActive)
+		b.ConnState(c, http.StateActive)
+		if total := a.ActiveConns() + b.ActiveConns(); total != 3 {
+			t.Fatalf("%d connections are tracked, want 3", total)
+		}
+	}
+}
+
+func TestTransferToKeepsSourceQuiet(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		begin func(*netutil.IdleTracker) (end func())
+	}{
+		{"BeginRequest", func(i *netutil.IdleTracker) func() { return i.BeginRequest() }},
+		{"Hold", func(i *netutil.IdleTracker) func() { return i.Hold() }},
+		{"Pause", func(i *netutil.IdleTracker) func() { i.Pause(); return i.Resume }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			src := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond)
+			dst := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+			end := tc.begin(src)
+			src.TransferTo(dst)
+			end()
+			select {
+			case <-src.Done():
+				t.Errorf("The source has fired after the transfer, with: %v", src.Err())
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestTransferToWhileSourceBusy(t *testing.T) {
+	src := netutil.NewIdleTracker(context.Background(), 20*time.Millisecond)
+	dst := netutil.NewIdleTracker(context.Background(), 1*time.Hour)
+	end := src.BeginRequest()
+	defer end()
+	<-time.After(50 * time.Millisecond) // Past the source's initial deadline.
+
+	src.TransferTo(dst)
+	if got, onDeadline := dst.Deadline(); !onDeadline || time.Until(got) < 30*time.Minute {
+		t.Errorf("The destination has taken over a stale deadline, got %v", got)
+	}
+	select {
+	case <-dst.Done():
+		t.Errorf("The destination has fired right after the transfer, with: %v", dst.Err())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
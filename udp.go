@@ -0,0 +1,30 @@
+// Copyright 2017 Mark Kubacki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// AcceptedUDPConn returns the passed UDP socket as *net.UDPConn,
+// which QUIC implementations and the like require for batch and GSO APIs.
+//
+// The file descriptor gets duplicated, hence the caller retains ownership
+// of f and can close it right away.
+// Sockets other than UDP ones are rejected with an error.
+func AcceptedUDPConn(f *os.File) (*net.UDPConn, error) {
+	pc, err := filePacketConn(f)
+	if err != nil {
+		return nil, err
+	}
+	uc, ok := pc.(*net.UDPConn)
+	if !ok {
+		pc.Close()
+		return nil, fmt.Errorf("netutil: %s is not a UDP socket, but %T", f.Name(), pc)
+	}
+	return uc, nil
+}
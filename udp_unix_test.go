@@ -0,0 +1,72 @@
+// This file is released into the public domain.
+
+//go:build !windows
+// +build !windows
+
+package netutil_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	netutil "github.com/wmark/go.netutil"
+)
+
+func TestAcceptedUDPConn(t *testing.T) {
+	orig, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("net.ListenUDP: %v", err)
+	}
+	f, err := orig.File()
+	orig.Close()
+	if err != nil {
+		t.Fatalf("File: %v", err)
+	}
+
+	conn, err := netutil.AcceptedUDPConn(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("netutil.AcceptedUDPConn: %v", err)
+	}
+	defer conn.Close()
+
+	client, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("net.DialUDP: %v", err)
+	}
+	defer client.Close()
+	client.Write([]byte("ping"))
+
+	buf := make([]byte, 16)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, peer, err := conn.ReadFromUDP(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("ReadFromUDP: %q, %v", buf[:n], err)
+	}
+	conn.WriteToUDP([]byte("pong"), peer)
+
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err = client.Read(buf)
+	if err != nil || string(buf[:n]) != "pong" {
+		t.Errorf("The reply didn't make it: %q, %v", buf[:n], err)
+	}
+}
+
+func TestAcceptedUDPConnRejectsTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("File: %v", err)
+	}
+	defer f.Close()
+
+	if conn, err := netutil.AcceptedUDPConn(f); err == nil {
+		conn.Close()
+		t.Error("A TCP socket has been accepted as UDP one")
+	}
+}